@@ -0,0 +1,257 @@
+package chef
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/chefsgo/base"
+)
+
+// Register 注册各种内容到当前程序
+func (k *App) Register(regs ...Any) {
+	k.register(regs...)
+}
+
+// Identify 声明当前程序的身份和版本
+func (k *App) Identify(role string, versions ...string) {
+	k.identify(role, versions...)
+}
+
+// Configure 修改当前程序的默认配置
+// 配置中的 ${ENV_NAME:-default} 和 ENC(...) 同样会被处理
+func (k *App) Configure(cfg Map) {
+	if err := k.resolveConfig(cfg); err != nil {
+		k.Warning("config resolve failed", Map{"error": err.Error()})
+	}
+	k.defaulting(cfg)
+}
+
+// Setting 获取setting，返回的是深度复制的一份
+func (k *App) Setting() Map {
+	return k.setting()
+}
+
+// Reload 运行中重新加载配置
+// 不传参数时，重新从文件、环境变量、命令行参数中加载
+func (k *App) Reload(configs ...Map) error {
+	var config Map
+	if len(configs) > 0 {
+		config = configs[0]
+		if err := k.resolveConfig(config); err != nil {
+			return fmt.Errorf("config %v", err)
+		}
+	}
+	return k.reload(config)
+}
+
+// Ready 准备好各模块，但是不启动
+// 有模块启动失败时，返回 *Failure
+// 有钩子中止启动时，返回 *HookFailure
+func (k *App) Ready() error {
+	// 终止以后不能再启动
+	select {
+	case <-k.stopped:
+		return errTerminated
+	default:
+	}
+	if err := k.parse(); err != nil {
+		return err
+	}
+	if err := k.bus(); err != nil {
+		return err
+	}
+	if err := k.cluster(); err != nil {
+		return err
+	}
+	if err := k.initialize(); err != nil {
+		return err
+	}
+	return k.connect()
+}
+
+// Go 直接开跑，阻塞直到收到退出信号，或是调用了 Stop
+// 不读取命令行参数，需要子命令或是命令行参数的，使用 Run
+func (k *App) Go(args ...string) error {
+	if l := len(args); l > 0 {
+		if l == 1 {
+			//role
+			k.identify(args[0])
+		} else {
+			//role, version
+			k.identify(args[0], args[1])
+		}
+	}
+
+	return k.Run([]string{})
+}
+
+// Run 按启动参数运行，args 和 os.Args 一样，第一个是程序名
+// 带了子命令时，如 app version, app check，执行完子命令后返回
+// 子命令的退出码不为0时，返回 *ExitError
+func (k *App) Run(args []string) error {
+	if done, err := k.dispatch(args); done {
+		return err
+	}
+
+	if err := k.Ready(); err != nil {
+		k.Error("ready failed", Map{"error": err.Error()})
+		return err
+	}
+	if err := k.launch(); err != nil {
+		k.Error("launch failed", Map{"error": err.Error()})
+		return err
+	}
+	k.waiting()
+	k.terminate()
+
+	return nil
+}
+
+// Isolate 隔离运行，只使用代码中 Configure 的配置
+// 不读取配置文件、远程配置、环境变量和命令行参数，一般用于测试
+// 需要在 Ready 或是 Go 之前调用
+func (k *App) Isolate() {
+	k.isolated = true
+}
+
+// Terminate 终止所有模块，用于配合 Ready 使用
+// 调用 Go 的时候，退出时会自动终止，不需要再调用，重复调用不会重复终止
+func (k *App) Terminate() {
+	k.terminate()
+}
+
+// Stop 停止运行，Go 会结束等待，并终止所有模块
+func (k *App) Stop() {
+	k.stop()
+}
+
+// Done 所有模块终止完成以后，此通道会被关闭
+func (k *App) Done() <-chan struct{} {
+	return k.stopped
+}
+
+func (k *App) Name() string {
+	return k.config.name
+}
+func (k *App) Role() string {
+	return k.config.role
+}
+func (k *App) Version() string {
+	return k.config.version
+}
+func (k *App) Mode() env {
+	return k.config.mode
+}
+func (k *App) Developing() bool {
+	return k.config.mode == developing
+}
+func (k *App) Testing() bool {
+	return k.config.mode == testing
+}
+func (k *App) Production() bool {
+	return k.config.mode == production
+}
+
+// Health 获取当前程序所有模块汇总的健康状态
+func (k *App) Health() Healths {
+	return k.health.Healths()
+}
+
+// Meta 创建一个属于当前程序的Meta
+// 通过它调用的方法、生成的字串和签名，都使用当前程序的注册表
+func (k *App) Meta(datas ...Metadata) *Meta {
+	meta := &Meta{app: k}
+	if len(datas) > 0 {
+		meta.Metadata(datas...)
+	}
+	return meta
+}
+
+// Services 所有服务的名称，服务可以被其它节点调用
+func (k *App) Services() []string {
+	return k.engine.services()
+}
+
+// Arguments 获取方法的参数定义
+func (k *App) Arguments(name string, extends ...Vars) Vars {
+	return k.engine.Arguments(name, extends...)
+}
+
+// Execute 直接执行，同步，本地
+func (k *App) Execute(name string, values ...Any) (Map, Res) {
+	var value Map
+	if len(values) > 0 {
+		if vv, ok := values[0].(Map); ok {
+			value = vv
+		}
+	}
+	return k.engine.Execute(nil, name, value)
+}
+
+// Trigger 触发执行，异步，本地
+func (k *App) Trigger(name string, values ...Any) {
+	var value Map
+	if len(values) > 0 {
+		if vv, ok := values[0].(Map); ok {
+			value = vv
+		}
+	}
+	k.engine.Trigger(nil, name, value)
+}
+
+// StateCode 返回状态码
+func (k *App) StateCode(name string, defs ...int) int {
+	return k.basic.StateCode(name, defs...)
+}
+
+// String 获取多语言字串
+func (k *App) String(lang, name string, args ...Any) string {
+	return k.basic.String(lang, name, args...)
+}
+
+// Types 获取所有类型定义
+func (k *App) Types() map[string]Type {
+	return k.basic.Types()
+}
+
+// Mapping 按定义解析参数
+func (k *App) Mapping(config Vars, data Map, value Map, argn bool, pass bool, zones ...*time.Location) Res {
+	return k.basic.Mapping(config, data, value, argn, pass, zones...)
+}
+
+// Codecs 获取所有编解码器
+func (k *App) Codecs() map[string]Codec {
+	return k.codec.Codecs()
+}
+
+// Encrypt 按编解码器加密
+func (k *App) Encrypt(name string, obj Any) (string, error) {
+	return k.codec.Encrypt(name, obj)
+}
+
+// Decrypt 按编解码器解密
+func (k *App) Decrypt(name string, obj Any) (Any, error) {
+	return k.codec.Decrypt(name, obj)
+}
+
+// Sign 生成签名
+func (k *App) Sign(auth bool, payload Map, ends ...time.Duration) string {
+	verify := &Token{Payload: payload}
+	verify.Header.Id = k.codec.Generate()
+	verify.Header.Auth = auth
+
+	now := time.Now()
+	if len(ends) > 0 {
+		verify.Header.End = now.Add(ends[0]).Unix()
+	}
+	token, err := k.token.Sign(verify)
+	if err != nil {
+		return ""
+	}
+	return token
+}
+
+// Verify 验证签名
+func (k *App) Verify(token string) (*Token, error) {
+	return k.token.Verify(token)
+}
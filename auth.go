@@ -0,0 +1,68 @@
+package chef
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/chefsgo/base"
+)
+
+// authorize 检查方法要求的token和授权
+// Token 需要有合法的token，Auth 需要token是已验证的
+// Claims 和 Scopes 检查token的负载，不满足返回 Unauthed
+// 通过返回nil
+func authorize(meta *Meta, config Method) Res {
+	needToken := config.Token || config.Auth || len(config.Claims) > 0 || len(config.Scopes) > 0
+	if needToken && meta.Signed() == false {
+		return Unsigned
+	}
+	if config.Auth && meta.Authed() == false {
+		return Unauthed
+	}
+
+	payload := meta.Payload()
+	for key, want := range config.Claims {
+		have, ok := payload[key]
+		if ok == false {
+			return Unauthed
+		}
+		// 经过编码以后数字的类型会变，所以按字串比较
+		if want != nil && fmt.Sprintf("%v", have) != fmt.Sprintf("%v", want) {
+			return Unauthed
+		}
+	}
+
+	if len(config.Scopes) > 0 {
+		scopes := map[string]bool{}
+		for _, scope := range payloadScopes(payload) {
+			scopes[scope] = true
+		}
+		for _, scope := range config.Scopes {
+			if scopes[scope] == false {
+				return Unauthed
+			}
+		}
+	}
+
+	return nil
+}
+
+// payloadScopes 负载中的 scopes
+// 支持字串数组，或是空格分隔的字串
+func payloadScopes(payload Map) []string {
+	switch vv := payload["scopes"].(type) {
+	case []string:
+		return vv
+	case []Any:
+		scopes := []string{}
+		for _, scope := range vv {
+			if s, ok := scope.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+		return scopes
+	case string:
+		return strings.Fields(vv)
+	}
+	return nil
+}
@@ -14,8 +14,9 @@ import (
 // basic不好独立，因为result依赖
 // codec 也得内置， 因为 mapping 依赖，  或是把 type+mapping独立
 
-var (
-	mBasic = &basicModule{
+func newBasicModule(app *App) *basicModule {
+	return &basicModule{
+		app:       app,
 		languages: make(map[string]Language, 0),
 		strings:   make(Strings, 0),
 
@@ -24,12 +25,13 @@ var (
 		regulars: make(Regulars, 0),
 		types:    make(map[string]Type, 0),
 	}
-)
+}
 
 type (
 	// basicModule 是基础模块
 	// 主要用功能是 状态、多语言字串、MIME类型、正则表达式等等
 	basicModule struct {
+		app       *App
 		mutex     sync.Mutex
 		languages map[string]Language
 		strings   Strings
@@ -93,6 +95,11 @@ type (
 	TypeValueFunc func(Any, Var) Any
 )
 
+// Name 模块名称
+func (this *basicModule) Name() string {
+	return "basic"
+}
+
 func (this *basicModule) Builtin() {
 }
 func (this *basicModule) Register(name string, value Any, override bool) {
@@ -133,6 +140,15 @@ func (this *basicModule) langConfigure(name string, config Map) {
 	if vvs, ok := config["accepts"].([]string); ok {
 		lang.Accepts = vvs
 	}
+	if vvs, ok := config["accepts"].([]Any); ok {
+		accepts := []string{}
+		for _, vv := range vvs {
+			if accept, ok := vv.(string); ok {
+				accepts = append(accepts, accept)
+			}
+		}
+		lang.Accepts = accepts
+	}
 	//这里覆盖
 	if vvs, ok := config["strings"].(map[string]string); ok {
 		for key, val := range vvs {
@@ -195,6 +211,29 @@ func (this *basicModule) Configure(value Map) {
 	// }
 }
 
+// Verify 多语言字串不需要校验
+func (this *basicModule) Verify(global Map) error {
+	return nil
+}
+
+// Reload 运行中重载多语言字串
+// lang 下每个语言一个节点，可以配置 name, text, accepts, strings
+func (this *basicModule) Reload(global Map) {
+	var config Map
+	if vv, ok := global["lang"].(Map); ok {
+		config = vv
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for key, val := range config {
+		if conf, ok := val.(Map); ok {
+			this.langConfigure(key, conf)
+		}
+	}
+}
+
 func (this *basicModule) Initialize() {
 }
 
@@ -217,6 +256,18 @@ func (this *basicModule) State(name string, config State, override bool) {
 		}
 	}
 }
+
+// results 注册 Result 定义的状态和默认字串
+func (this *basicModule) results(defines []resultDefine) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for _, define := range defines {
+		this.State(define.state, State(define.code), define.override)
+		this.Strings(DEFAULT, Strings{define.state: define.text}, define.override)
+	}
+}
+
 func (this *basicModule) States(config States, override bool) {
 	for key, val := range config {
 		this.State(key, State(val), override)
@@ -743,7 +794,7 @@ func (this *basicModule) Mapping(config Vars, data Map, value Map, argn bool, pa
 					// if sv,ok := fieldValue.(string); ok {
 
 					//得到解密方法
-					if val, err := mCodec.Decrypt(fieldConfig.Decode, strVal); err == nil {
+					if val, err := this.app.codec.Decrypt(fieldConfig.Decode, strVal); err == nil {
 						//前方解过密了，表示该参数，不再加密
 						//因为加密解密，只有一个2选1的
 						//比如 args 只需要解密 data 只需要加密
@@ -906,7 +957,7 @@ func (this *basicModule) Mapping(config Vars, data Map, value Map, argn bool, pa
 				*/
 
 				// encrypt := mCodec.getEncrypt(fieldConfig.Encode)
-				if val, err := mCodec.Encrypt(fieldConfig.Encode, fieldValue); err == nil {
+				if val, err := this.app.codec.Encrypt(fieldConfig.Encode, fieldValue); err == nil {
 					fieldValue = val
 				}
 			}
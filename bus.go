@@ -0,0 +1,499 @@
+package chef
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/chefsgo/base"
+)
+
+var (
+	errBusClosed  = errors.New("bus closed")
+	errBusAddress = errors.New("invalid bus address")
+	errBusTimeout = &busTimeoutError{}
+
+	// loopbacks 进程内的总线，按地址存储
+	loopbackMutex sync.RWMutex
+	loopbacks     = map[string]BusHandler{}
+)
+
+// 总线使用gob编码，数据的类型和本地调用一样
+// 数据中有其它自定义类型的，需要自己 gob.Register
+func init() {
+	gob.Register(Map{})
+	gob.Register([]Map{})
+	gob.Register([]Any{})
+	gob.Register([]int64{})
+	gob.Register([]float64{})
+	gob.Register(time.Time{})
+}
+
+type (
+	// BusRequest 发给其它节点的调用
+	BusRequest struct {
+		Name     string
+		Metadata Metadata
+		Value    Map
+		// Deadline 调用方的期限，对方节点在此期限内执行
+		Deadline time.Time
+	}
+
+	// BusResponse 其它节点返回的结果
+	BusResponse struct {
+		Data  Map
+		Code  int
+		State string
+		Args  []Any
+		// Type 调用的类型，invoke, invokes 等
+		Type string
+	}
+
+	// BusHandler 收到其它节点的调用时的处理
+	BusHandler func(request BusRequest) BusResponse
+
+	// Bus 节点之间调用的总线
+	Bus interface {
+		// Address 当前节点的总线地址，没有配置集群地址时，会作为节点地址通告
+		Address() string
+		// Request 调用指定地址的节点
+		Request(address string, request BusRequest, timeout time.Duration) (BusResponse, error)
+		// Close 关闭总线，不再接收调用
+		Close() error
+	}
+
+	// BusDriver 总线驱动
+	// 按配置中 bus.driver 选择驱动，config 为 bus 配置节
+	BusDriver interface {
+		Open(config Map, handler BusHandler) (Bus, error)
+	}
+
+	busConfig struct {
+		// Driver 总线驱动，为空表示不使用总线，只能调用本地方法
+		Driver string
+		// Timeout 调用其它节点的超时时间
+		Timeout time.Duration
+	}
+
+	// remoting 总线的运行状态
+	remoting struct {
+		mutex   sync.RWMutex
+		config  busConfig
+		drivers map[string]BusDriver
+		bus     Bus
+		// next 轮询选择节点
+		next uint64
+	}
+
+	// loopbackBusDriver 进程内的总线，一般用于测试
+	loopbackBusDriver struct{}
+	loopbackBus       struct {
+		address string
+	}
+
+	// socketBusDriver 基于 TCP 或是 Unix Socket 的总线
+	// 地址为 tcp://127.0.0.1:7700 或是 unix:///tmp/chef.sock
+	// idle 连接空闲多久没有收到调用就关闭，默认1分钟
+	socketBusDriver struct {
+		app     *App
+		network string
+	}
+	socketBus struct {
+		app      *App
+		address  string
+		listener net.Listener
+		handler  BusHandler
+		idle     time.Duration
+		closed   int32
+	}
+
+	// busTimeoutError 调用超时，符合 net.Error
+	busTimeoutError struct{}
+)
+
+func (err *busTimeoutError) Error() string   { return "bus request timeout" }
+func (err *busTimeoutError) Timeout() bool   { return true }
+func (err *busTimeoutError) Temporary() bool { return true }
+
+//------------------------- app ----------------------------
+
+// busDriver 注册总线驱动
+func (k *App) busDriver(name string, driver BusDriver, override bool) {
+	k.remoting.mutex.Lock()
+	defer k.remoting.mutex.Unlock()
+
+	if k.remoting.drivers == nil {
+		k.remoting.drivers = make(map[string]BusDriver, 0)
+	}
+	if override {
+		k.remoting.drivers[name] = driver
+	} else {
+		if _, ok := k.remoting.drivers[name]; ok == false {
+			k.remoting.drivers[name] = driver
+		}
+	}
+}
+
+// bus 打开总线
+// 没有配置 bus.driver 时，不使用总线
+func (k *App) bus() error {
+	k.mutex.RLock()
+	config, _ := k.global["bus"].(Map)
+	k.mutex.RUnlock()
+
+	cfg := busConfig{Timeout: time.Second * 5}
+	cfg.Driver, _ = config["driver"].(string)
+	if timeout := parseDurationFromMap(config, "timeout"); timeout > 0 {
+		cfg.Timeout = timeout
+	}
+
+	k.remoting.mutex.Lock()
+	k.remoting.config = cfg
+	driver, ok := k.remoting.drivers[cfg.Driver]
+	opened := k.remoting.bus != nil
+	k.remoting.mutex.Unlock()
+
+	if cfg.Driver == "" || opened {
+		return nil
+	}
+	if ok == false {
+		return fmt.Errorf("bus unknown driver %s", cfg.Driver)
+	}
+
+	bus, err := driver.Open(config, k.serving)
+	if err != nil {
+		return fmt.Errorf("bus %s: %v", cfg.Driver, err)
+	}
+
+	k.remoting.mutex.Lock()
+	k.remoting.bus = bus
+	k.remoting.mutex.Unlock()
+	return nil
+}
+
+// unbus 关闭总线
+func (k *App) unbus() {
+	k.remoting.mutex.Lock()
+	bus := k.remoting.bus
+	k.remoting.bus = nil
+	k.remoting.mutex.Unlock()
+
+	if bus == nil {
+		return
+	}
+	if err := bus.Close(); err != nil {
+		k.Warning("bus close failed", Map{"error": err.Error()})
+	}
+}
+
+// busAddress 总线的地址，没有总线时为空
+func (k *App) busAddress() string {
+	k.remoting.mutex.RLock()
+	defer k.remoting.mutex.RUnlock()
+
+	if k.remoting.bus == nil {
+		return ""
+	}
+	return k.remoting.bus.Address()
+}
+
+// serving 处理其它节点的调用，只能调用服务
+func (k *App) serving(request BusRequest) BusResponse {
+	meta := k.Meta(request.Metadata)
+	defer meta.close()
+
+	// 带上调用方的期限，超过期限的不用再执行
+	if request.Deadline.IsZero() == false {
+		cx, cancel := context.WithDeadline(context.Background(), request.Deadline)
+		defer cancel()
+		meta.WithContext(cx)
+	}
+
+	data, res, tttt := k.engine.serve(meta, request.Name, request.Value)
+	if res == nil {
+		res = OK
+	}
+	return BusResponse{Data: data, Code: res.Code(), State: res.State(), Args: res.Args(), Type: tttt}
+}
+
+// providers 集群中提供此服务的其它节点
+func (k *App) providers(name string) []Node {
+	self := nodeKey(k.node())
+
+	providers := []Node{}
+	for _, node := range k.Nodes() {
+		if nodeKey(node) == self || node.Address == "" {
+			continue
+		}
+		for _, method := range node.Methods {
+			if method == name {
+				providers = append(providers, node)
+				break
+			}
+		}
+	}
+	return providers
+}
+
+// forward 通过总线调用其它节点的服务
+// 没有总线，或是没有节点提供此服务时，返回 Nothing
+func (k *App) forward(meta *Meta, name string, value Map) (Map, Res, string) {
+	k.remoting.mutex.RLock()
+	bus := k.remoting.bus
+	timeout := k.remoting.config.Timeout
+	k.remoting.mutex.RUnlock()
+
+	if bus == nil {
+		return nil, Nothing, engineInvoke
+	}
+
+	providers := k.providers(name)
+	if len(providers) == 0 {
+		return nil, Nothing, engineInvoke
+	}
+	node := providers[atomic.AddUint64(&k.remoting.next, 1)%uint64(len(providers))]
+
+	if meta == nil {
+		meta = k.Meta()
+	}
+
+	// 调用时指定的超时优先，不能超过调用方的期限
+	if meta.timeout > 0 {
+		timeout = meta.timeout
+	}
+	if deadline, ok := meta.Deadline(); ok {
+		remain := time.Until(deadline)
+		if remain <= 0 {
+			return nil, Timeout, engineInvoke
+		}
+		if timeout <= 0 || remain < timeout {
+			timeout = remain
+		}
+	}
+
+	request := BusRequest{Name: name, Metadata: meta.Metadata(), Value: value}
+	if timeout > 0 {
+		request.Deadline = time.Now().Add(timeout)
+	}
+
+	response, err := bus.Request(node.Address, request, timeout)
+	if err != nil {
+		if vv, ok := err.(net.Error); ok && vv.Timeout() {
+			return nil, Timeout, engineInvoke
+		}
+		return nil, errorResult(err), engineInvoke
+	}
+
+	var res Res = OK
+	if response.Code != 0 || response.State != OK.State() {
+		res = newResult(response.Code, response.State, response.Args...)
+	}
+	return response.Data, res, response.Type
+}
+
+//------------------------- loopback ----------------------------
+
+func (driver *loopbackBusDriver) Open(config Map, handler BusHandler) (Bus, error) {
+	address := "loopback://" + nodeId()
+	if name, ok := config["name"].(string); ok && name != "" {
+		address = "loopback://" + name
+	}
+
+	loopbackMutex.Lock()
+	defer loopbackMutex.Unlock()
+
+	if _, ok := loopbacks[address]; ok {
+		return nil, fmt.Errorf("%s already in use", address)
+	}
+	loopbacks[address] = handler
+	return &loopbackBus{address}, nil
+}
+
+func (bus *loopbackBus) Address() string {
+	return bus.address
+}
+
+func (bus *loopbackBus) Request(address string, request BusRequest, timeout time.Duration) (BusResponse, error) {
+	loopbackMutex.RLock()
+	handler, ok := loopbacks[address]
+	loopbackMutex.RUnlock()
+
+	if ok == false {
+		return BusResponse{}, fmt.Errorf("%s not found", address)
+	}
+
+	// 和其它总线一样经过编码，不和调用方共用数据
+	if err := busCopy(request, &request); err != nil {
+		return BusResponse{}, err
+	}
+
+	done := make(chan BusResponse, 1)
+	go func() {
+		done <- handler(request)
+	}()
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		timer = time.After(timeout)
+	}
+
+	select {
+	case response := <-done:
+		err := busCopy(response, &response)
+		return response, err
+	case <-timer:
+		return BusResponse{}, errBusTimeout
+	}
+}
+
+// busCopy 用gob编码再解码，得到一份独立的数据
+func busCopy(src Any, dst Any) error {
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(src); err != nil {
+		return err
+	}
+	return gob.NewDecoder(&buf).Decode(dst)
+}
+
+func (bus *loopbackBus) Close() error {
+	loopbackMutex.Lock()
+	delete(loopbacks, bus.address)
+	loopbackMutex.Unlock()
+	return nil
+}
+
+//------------------------- socket ----------------------------
+
+// busDial 按地址连接，支持 tcp:// 和 unix://
+func busDial(address string, timeout time.Duration) (net.Conn, error) {
+	kv := strings.SplitN(address, "://", 2)
+	if len(kv) != 2 || (kv[0] != "tcp" && kv[0] != "unix") {
+		return nil, errBusAddress
+	}
+	return net.DialTimeout(kv[0], kv[1], timeout)
+}
+
+func (driver *socketBusDriver) Open(config Map, handler BusHandler) (Bus, error) {
+	address, _ := config["address"].(string)
+	if address == "" {
+		if driver.network == "unix" {
+			address = fmt.Sprintf("%s/chef-%s.sock", os.TempDir(), nodeId())
+		} else {
+			address = "127.0.0.1:0"
+		}
+	}
+	if driver.network == "unix" {
+		if _, err := os.Stat(address); err == nil {
+			// 还能连上的，是正在运行的节点，连不上的才是上次没有正常退出留下的文件
+			if conn, err := net.DialTimeout("unix", address, time.Second); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("%s address in use", address)
+			}
+			os.Remove(address)
+		}
+	}
+
+	idle := parseDurationFromMap(config, "idle")
+	if idle <= 0 {
+		idle = time.Minute
+	}
+
+	listener, err := net.Listen(driver.network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	bus := &socketBus{
+		app: driver.app, address: driver.network + "://" + listener.Addr().String(),
+		listener: listener, handler: handler, idle: idle,
+	}
+	go bus.accepting()
+
+	return bus, nil
+}
+
+func (bus *socketBus) Address() string {
+	return bus.address
+}
+
+// accepting 接收连接，直到关闭
+// 出错时等待一下再继续，从5毫秒开始加倍，最多1秒，避免空转
+func (bus *socketBus) accepting() {
+	var delay time.Duration
+	for {
+		conn, err := bus.listener.Accept()
+		if err != nil {
+			if atomic.LoadInt32(&bus.closed) == 1 {
+				return
+			}
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > time.Second {
+				delay = time.Second
+			}
+			bus.app.Warning("bus accept failed", Map{"error": err.Error(), "retry": delay.String()})
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		go bus.serving(conn)
+	}
+}
+
+// serving 一个连接上可以有多次调用，按顺序处理
+// 空闲太久的连接关闭，对方可能已经断开了，不能一直占着协程
+func (bus *socketBus) serving(conn net.Conn) {
+	defer conn.Close()
+
+	decoder := gob.NewDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+	for {
+		request := BusRequest{}
+		conn.SetReadDeadline(time.Now().Add(bus.idle))
+		if err := decoder.Decode(&request); err != nil {
+			return
+		}
+		if err := encoder.Encode(bus.handler(request)); err != nil {
+			return
+		}
+	}
+}
+
+func (bus *socketBus) Request(address string, request BusRequest, timeout time.Duration) (BusResponse, error) {
+	response := BusResponse{}
+	if atomic.LoadInt32(&bus.closed) == 1 {
+		return response, errBusClosed
+	}
+
+	conn, err := busDial(address, timeout)
+	if err != nil {
+		return response, err
+	}
+	defer conn.Close()
+
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := gob.NewEncoder(conn).Encode(request); err != nil {
+		return response, err
+	}
+	if err := gob.NewDecoder(conn).Decode(&response); err != nil {
+		return response, err
+	}
+	return response, nil
+}
+
+func (bus *socketBus) Close() error {
+	if atomic.SwapInt32(&bus.closed, 1) == 1 {
+		return nil
+	}
+	return bus.listener.Close()
+}
@@ -0,0 +1,222 @@
+package chef_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/chefsgo/base"
+	"github.com/chefsgo/chef"
+	"github.com/chefsgo/chef/cheftest"
+)
+
+// busApps 启动提供服务和调用服务的两个程序，通过目录集群互相发现
+// fixtures 注册到提供服务的程序
+func busApps(t *testing.T, driver string, fixtures ...Any) (*cheftest.Tester, *cheftest.Tester) {
+	dir := t.TempDir()
+
+	start := func(role string, fixtures ...Any) *cheftest.Tester {
+		bus := Map{"driver": driver, "timeout": "200ms"}
+		if driver == "unix" {
+			bus["address"] = filepath.Join(dir, role+".sock")
+		}
+		config := Map{
+			"role":    role,
+			"bus":     bus,
+			"cluster": Map{"driver": "file", "dir": filepath.Join(dir, "nodes"), "interval": "20ms"},
+		}
+		return cheftest.Go(t, append([]Any{config}, fixtures...)...)
+	}
+
+	provider := start("provider", fixtures...)
+	caller := start("caller")
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		for _, node := range caller.Nodes() {
+			if node.Role == "provider" && len(node.Methods) > 0 {
+				return provider, caller
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for provider")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBus(t *testing.T) {
+	for _, driver := range []string{"loopback", "tcp", "unix"} {
+		t.Run(driver, func(t *testing.T) {
+			testBus(t, driver)
+		})
+	}
+}
+
+func testBus(t *testing.T, driver string) {
+	release := make(chan struct{})
+	now := time.Date(2022, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	provider, caller := busApps(t, driver,
+		"echo", chef.Service{
+			Action: func(ctx *chef.Context) Map {
+				return Map{
+					"name": ctx.Value["name"], "count": int64(3), "rate": 1.5, "time": now,
+					"tags": []string{"a", "b"}, "child": Map{"ok": true},
+				}
+			},
+		},
+		"list", chef.Service{
+			Action: func(ctx *chef.Context) (int64, []Map) {
+				return 2, []Map{{"id": int64(1)}, {"id": int64(2)}}
+			},
+		},
+		"local", chef.Method{
+			Action: func(ctx *chef.Context) Map {
+				return Map{"ok": true}
+			},
+		},
+		"retry", chef.Service{
+			Action: func(ctx *chef.Context) Res {
+				return chef.Retry
+			},
+		},
+		"deadline", chef.Service{
+			Action: func(ctx *chef.Context) Map {
+				deadline, ok := ctx.Deadline()
+				return Map{"ok": ok, "left": int64(time.Until(deadline))}
+			},
+		},
+		"slow", chef.Service{
+			Action: func(ctx *chef.Context) {
+				<-release
+			},
+		},
+	)
+	// 先放开阻塞的方法，程序才能正常终止
+	t.Cleanup(func() { close(release) })
+
+	t.Run("invoke", func(t *testing.T) {
+		local := provider.Meta()
+		remote := caller.Meta()
+
+		expected := local.Invoke("echo", Map{"name": "chef"})
+		actual := remote.Invoke("echo", Map{"name": "chef"})
+		if res := remote.Result(); res != nil && res.Fail() {
+			t.Fatalf("remote invoke: %v", res.State())
+		}
+		if reflect.DeepEqual(expected, actual) == false {
+			t.Errorf("remote data differs from local\nlocal:  %#v\nremote: %#v", expected, actual)
+		}
+
+		expectedCount, expectedItems := local.Invoking("list", 0, 10)
+		actualCount, actualItems := remote.Invoking("list", 0, 10)
+		if expectedCount != actualCount || reflect.DeepEqual(expectedItems, actualItems) == false {
+			t.Errorf("remote list differs from local\nlocal:  %d %v\nremote: %d %v", expectedCount, expectedItems, actualCount, actualItems)
+		}
+	})
+
+	t.Run("method", func(t *testing.T) {
+		meta := caller.Meta()
+		meta.Invoke("local")
+		if res := meta.Result(); res == nil || res.State() != chef.Nothing.State() {
+			t.Errorf("expected nothing for unexposed method, got %v", res)
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		local := provider.Meta()
+		remote := caller.Meta()
+
+		local.Invoke("retry")
+		remote.Invoke("retry")
+		expected, actual := local.Result(), remote.Result()
+		if expected.State() != chef.Fail.State() || actual.State() != expected.State() {
+			t.Errorf("expected fail for both, local %v, remote %v", expected, actual)
+		}
+	})
+
+	t.Run("execute", func(t *testing.T) {
+		// 只执行本地的方法，不转发到其它节点
+		if _, res := caller.Execute("echo"); res == nil || res.State() != chef.Nothing.State() {
+			t.Errorf("expected nothing for remote execute, got %v", res)
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		meta := caller.Meta()
+		meta.Timeout(50 * time.Millisecond)
+		data := meta.Invoke("deadline")
+		if ok, _ := data["ok"].(bool); ok == false {
+			t.Fatalf("expected remote deadline, got %v", data)
+		}
+		if left, _ := data["left"].(int64); left <= 0 || left > int64(50*time.Millisecond) {
+			t.Errorf("remote deadline should follow caller timeout, %v left", time.Duration(left))
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		meta := caller.Meta()
+		start := time.Now()
+		meta.Invoke("slow")
+		if res := meta.Result(); res == nil || res.State() != chef.Timeout.State() {
+			t.Errorf("expected timeout, got %v", res)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("timeout took %v", elapsed)
+		}
+	})
+}
+
+func TestBusUnixAddress(t *testing.T) {
+	address := filepath.Join(t.TempDir(), "chef.sock")
+
+	ready := func() (*chef.App, error) {
+		app := chef.New()
+		app.Isolate()
+		app.Configure(Map{"bus": Map{"driver": "unix", "address": address}})
+		return app, app.Ready()
+	}
+
+	// 上次没有正常退出，留下的文件
+	if err := os.WriteFile(address, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	first, err := ready()
+	if err != nil {
+		t.Fatalf("stale socket should be removed: %v", err)
+	}
+	defer first.Terminate()
+
+	second, err := ready()
+	if err == nil {
+		second.Terminate()
+		t.Fatal("expected address in use")
+	}
+	if strings.Contains(err.Error(), "in use") == false {
+		t.Errorf("expected address in use, got %v", err)
+	}
+}
+
+func TestBusIdle(t *testing.T) {
+	app := cheftest.New(t, Map{"bus": Map{"driver": "tcp", "idle": "50ms"}})
+	address := strings.TrimPrefix(app.Nodes()[0].Address, "tcp://")
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 连上以后什么也不发，空闲超时后会被对方关闭
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected closed connection")
+	} else if vv, ok := err.(net.Error); ok && vv.Timeout() {
+		t.Fatal("idle connection not closed")
+	}
+}
@@ -1,20 +1,26 @@
 package chef
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"os"
 	"os/signal"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	. "github.com/chefsgo/base"
 )
 
+var (
+	errTerminated = errors.New("App terminated.")
+)
+
 type (
-	chef struct {
+	// App 一个chef程序，拥有自己的模块、注册表和配置
+	// 使用 chef.New() 创建，包级别的方法都是操作默认的程序
+	App struct {
 		parsed      bool
 		initialized bool
 		connected   bool
@@ -23,6 +29,59 @@ type (
 		mutex   sync.RWMutex
 		config  config
 		modules []Module
+
+		// 内置模块
+		logger *loggerModule
+		basic  *basicModule
+		codec  *codecModule
+		token  *tokenModule
+		engine *engineModule
+		health *healthModule
+
+		// stopper 关闭后，waiting 就会结束等待
+		stopper  chan struct{}
+		stopping sync.Once
+		// stopped 所有模块终止完成后关闭
+		stopped chan struct{}
+		// terminated 保证只终止一次
+		terminated sync.Once
+
+		// watchers 配置重载后，接收变化的setting
+		watchers []SettingWatcher
+
+		// drivers 配置源驱动，按scheme注册
+		drivers map[string]ConfigDriver
+		// source 当前使用的配置源
+		source    ConfigSource
+		sourceUri string
+
+		// isolated 隔离运行，不读取配置文件、远程配置、环境变量和命令行参数
+		isolated bool
+
+		// hooks 生命周期钩子，按阶段存储
+		hooks map[string][]Hook
+
+		// global 合并后的完整配置，模块通过句柄读取自己的配置节
+		global Map
+		// defaults 代码中设置的默认配置，重载时作为基础
+		defaults Map
+		// hosts 已经创建的模块句柄
+		hosts []*moduleHost
+
+		// clustering 集群成员
+		clustering clustering
+		// remoting 节点之间调用的总线
+		remoting remoting
+
+		// commands 注册的子命令
+		commands map[string]Command
+		// tasks 注册的任务
+		tasks map[string]Task
+
+		// timings 各模块在各阶段的耗时
+		timings timings
+		// args 去掉子命令以后的启动参数
+		args []string
 	}
 	config struct {
 		// name 项目名称
@@ -40,34 +99,107 @@ type (
 		// 一般是为了远程获取配置
 		config string
 
+		// shutdown 退出时，触发器和每个模块终止的超时时间
+		// 超时的会被跳过，为0表示一直等待
+		shutdown time.Duration
+
+		// ready 启动时，等待异步模块准备好的超时时间
+		// 超时的会记录日志后继续启动，为0表示一直等待
+		ready time.Duration
+
 		// setting 设置，主要是自定义的setting
 		// 实际业务代码中一般需要用的配置
 		setting Map
+
+		// schema setting的定义，解析和重载时校验
+		schema Vars
 	}
+
+	// SettingWatcher 配置重载以后的回调
+	// changes 为有变化的setting路径，如 mail.host
+	SettingWatcher func(changes []string)
 )
 
 // setting 获取setting
-func (this *chef) setting() Map {
+func (this *App) setting() Map {
 	this.mutex.RLock()
 	defer this.mutex.RUnlock()
 
-	// 为了线程安全，为了避免外部修改，这里复制一份
-	// 需要不需要深度复制？二层以下的map,[]map会不会因为引用的关系被修改？
-	// 以上需要测试go的特性再处理，但是通常不会有什么问题，待优化
-	setting := Map{}
-	for k, v := range this.config.setting {
-		setting[k] = v
+	// 为了线程安全，为了避免外部修改，这里深度复制一份
+	return cloneMap(this.config.setting)
+}
+
+// define 注册setting的定义
+// 多次注册时合并，同名的后注册的覆盖
+func (this *App) define(schema Vars) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.config.schema == nil {
+		this.config.schema = Vars{}
+	}
+	for key, val := range schema {
+		this.config.schema[key] = val
+	}
+}
+
+// validate 按定义校验setting
+// 通过后返回带上默认值、转换过类型的setting，定义之外的key原样保留
+// 失败返回 varEmpty, varError 或是定义中自定义的状态
+func (this *App) validate(setting Map) (Map, error) {
+	if len(this.config.schema) == 0 {
+		return setting, nil
 	}
 
-	return setting
+	value := Map{}
+	res := this.basic.Mapping(this.config.schema, setting, value, false, false)
+	if res != nil && res.Fail() {
+		return nil, fmt.Errorf("setting %w", res)
+	}
+
+	valid := Map{}
+	for key, val := range setting {
+		valid[key] = val
+	}
+	for key, val := range value {
+		valid[key] = val
+	}
+	return valid, nil
 }
 
-// loader 把模块注册到core
+// loader 把模块注册到程序
 // 遍历所有已经注册过的模块，避免重复注册
-func (this *chef) loader(mod Module) {
+func (this *App) loader(mod Module) {
 	this.mutex.Lock()
-	defer this.mutex.Unlock()
 	this.modules = append(this.modules, mod)
+	this.mutex.Unlock()
+
+	// 实现了 Attacher 的模块，给它一个句柄
+	this.attach(mod)
+}
+
+// settingWatcher 注册配置重载的回调
+func (k *App) settingWatcher(watcher SettingWatcher) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.watchers = append(k.watchers, watcher)
+}
+
+// driver 注册配置源驱动
+func (k *App) driver(name string, driver ConfigDriver, override bool) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.drivers == nil {
+		k.drivers = make(map[string]ConfigDriver, 0)
+	}
+	if override {
+		k.drivers[name] = driver
+	} else {
+		if _, ok := k.drivers[name]; ok == false {
+			k.drivers[name] = driver
+		}
+	}
 }
 
 // register 遍历所有模块调用注册
@@ -76,8 +208,8 @@ func (this *chef) loader(mod Module) {
 // name, config, override 包括name的注册
 // config, override	不包括name的注册，比如  langstring, regular, mimetype等
 // configs
-// func (k *chef) register(name string, config Any, overrides ...bool) {
-func (k *chef) register(regs ...Any) {
+// func (k *App) register(name string, config Any, overrides ...bool) {
+func (k *App) register(regs ...Any) {
 	name := ""
 	configs := make([]Any, 0)
 	override := true
@@ -96,10 +228,37 @@ func (k *chef) register(regs ...Any) {
 	for _, cfg := range configs {
 		if mmm, ok := cfg.(Map); ok {
 			// 兼容所有模块的配置注册
-			k.configure(mmm)
+			if err := k.resolveConfig(mmm); err != nil {
+				k.Warning("config resolve failed", Map{"error": err.Error()})
+			}
+			k.defaulting(mmm)
 		} else if mod, ok := cfg.(Module); ok {
 			// 兼容所有模块的配置注册
 			k.loader(mod)
+		} else if watcher, ok := cfg.(SettingWatcher); ok {
+			k.settingWatcher(watcher)
+		} else if watcher, ok := cfg.(func(changes []string)); ok {
+			// 直接写的函数，类型不是 SettingWatcher
+			k.settingWatcher(watcher)
+		} else if driver, ok := cfg.(ConfigDriver); ok {
+			k.driver(name, driver, override)
+		} else if hook, ok := cfg.(Hook); ok {
+			k.hook(name, hook)
+		} else if command, ok := cfg.(Command); ok {
+			k.command(name, command, override)
+		} else if task, ok := cfg.(Task); ok {
+			k.task(name, task, override)
+		} else if driver, ok := cfg.(ClusterDriver); ok {
+			k.clusterDriver(name, driver, override)
+		} else if driver, ok := cfg.(BusDriver); ok {
+			k.busDriver(name, driver, override)
+		} else if watcher, ok := cfg.(NodeWatcher); ok {
+			k.nodeWatcher(watcher)
+		} else if watcher, ok := cfg.(func(event string, node Node)); ok {
+			k.nodeWatcher(watcher)
+		} else if schema, ok := cfg.(Vars); ok && name == "setting" {
+			// Register("setting", Vars{...}) 定义setting
+			k.define(schema)
 		} else {
 			//实际注册到各模块
 			for _, mod := range k.modules {
@@ -110,78 +269,242 @@ func (k *chef) register(regs ...Any) {
 
 }
 
-// parse 解析启动参数，参数有以下几个来源
-// 1 命令行参数，直接传在启动命令后面
-// 2 环境变量，各种参数单独传过来
-// 3 环境变量，就像命令行参数一样，整个传过来
+// parse 解析启动参数，配置有以下几个来源，按优先级从低到高合并
+// 1 代码中调用 Configure 设置的默认配置
+// 2 配置文件，命令行中指定的文件，或是按默认文件列表查找到的第一个文件
+// 3 远程配置，配置了 config 时，按 config 的scheme选择配置源，用节点身份加载
+// 4 环境变量，以 CHEF_ 开头，双下划线表示层级，如 CHEF_TOKEN__SECRET 对应 token.secret
+// 5 命令行参数，--name, --role, --version, --mode, 以及可多次指定的 --set key.path=value
+// 2-5 全部合并完成以后，才统一调用 configure 下发到各模块
+// 配置文件支持 toml, json, yaml 格式，先合并 include 的文件，再合并运行模式的覆盖文件
+// 合并后所有字串中的 ${ENV_NAME:-default} 会被替换，ENC(...) 会用 CHEF_SECRET_KEY 解密
 // 主要是方便在docker中启动，或是其它容器
-// 以上功能待处理
-func (k *chef) parse() {
+func (k *App) parse() error {
 	if k.parsed {
-		return
+		return nil
+	}
+	if err := k.hooking(BeforeParse); err != nil {
+		return err
+	}
+
+	config, err := k.loading(k.arguments(), nil)
+	if err != nil {
+		return err
+	}
+
+	if err := k.hooking(BeforeConfigure); err != nil {
+		return err
+	}
+	k.configure(config)
+	if err := k.hooking(AfterConfigure); err != nil {
+		return err
+	}
+
+	// 按定义校验setting，缺少或是无效的直接失败
+	k.mutex.Lock()
+	setting, err := k.validate(k.config.setting)
+	if err == nil {
+		k.config.setting = setting
+	}
+	k.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := k.hooking(AfterParse); err != nil {
+		return err
+	}
+	k.parsed = true
+
+	return nil
+}
+
+// loading 从文件、远程配置源、环境变量、命令行参数中加载配置，并按优先级合并
+// remote 不为nil时直接使用，不再从配置源加载，用于配置源的变化通知
+func (k *App) loading(args []string, remote Map) (Map, error) {
+	// 隔离运行时只使用代码中的配置
+	if k.isolated {
+		return Map{}, nil
 	}
 
-	args := os.Args
+	file, flags := parseFlags(args)
 
 	// 定义一个文件列表，尝试读取配置
 	files := []string{
 		"config.toml", "chefgo.toml", "chef.toml",
 		"config.conf", "chefgo.conf", "chef.conf",
+		"config.json", "config.yaml", "config.yml",
 	}
-	// 如果参数个数为1，表示没有传参数，使用文件名
-	if len(args) == 1 {
+	// 如果没有指定配置文件，优先使用程序同名的文件
+	if file == "" && len(args) > 0 {
 		base := getBaseWithoutExt(args[0])
 		files = append([]string{
 			base + ".toml", base + ".conf",
+			base + ".json", base + ".yaml", base + ".yml",
 		}, files...)
 	}
-	// 如果参数个数为2，就是指定了配置文件
-	if len(args) == 2 {
-		files = append([]string{args[1]}, files...)
+	// 指定了配置文件
+	if file != "" {
+		files = append([]string{file}, files...)
 	}
 
-	// 遍历文件
+	config := Map{}
+	envs := parseEnvs(os.Environ())
+
+	// 遍历文件，只读取第一个存在的文件，以及它 include 的文件
 	for _, file := range files {
-		// 判断文件是否存在
-		if _, err := os.Stat(file); err == nil {
-			// 读取文件
-			bytes, err := ioutil.ReadFile(file)
-			if err == nil {
-				// 加载配置，并中断循环，只读取第一个读到的文件
-				config := parseTOML(string(bytes))
-				core.configure(config)
-				break
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+
+		vv, err := readConfig(file, map[string]bool{})
+		if err != nil {
+			return nil, err
+		}
+		mergeMap(config, vv)
+
+		// 按运行模式加载覆盖文件，如 config.production.toml
+		// 运行模式以命令行参数、环境变量、文件的顺序确定
+		mode := k.config.mode
+		for _, vv := range []Map{config, envs, flags} {
+			if vv, ok := vv["mode"].(string); ok {
+				mode = parseMode(vv)
+			}
+		}
+		overlay := overlayFile(file, mode)
+		if _, err := os.Stat(overlay); err == nil {
+			vv, err := readConfig(overlay, map[string]bool{})
+			if err != nil {
+				return nil, err
 			}
+			mergeMap(config, vv)
 		}
+		break
 	}
 
-	// 待处理，从环境变量，和命令行读取配置
+	// 只有文件和远程配置需要替换环境变量，解密 ENC(...)
+	// 环境变量和命令行参数的值原样使用，不会再次替换
+	if err := k.resolveConfig(config); err != nil {
+		return nil, fmt.Errorf("config %v", err)
+	}
+
+	// 远程配置，需要先合并其它来源，才能知道 config 以及节点身份
+	if remote == nil {
+		overrides := mergeMap(mergeMap(mergeMap(Map{}, config), envs), flags)
+		vv, err := k.remote(overrides)
+		if err != nil {
+			return nil, err
+		}
+		remote = vv
+	}
+	remote = cloneMap(remote)
+	if err := k.resolveConfig(remote); err != nil {
+		return nil, fmt.Errorf("remote %v", err)
+	}
+	mergeMap(config, remote)
+
+	// 环境变量覆盖文件和远程配置
+	mergeMap(config, envs)
+
+	// 命令行参数覆盖环境变量
+	mergeMap(config, flags)
+
+	return config, nil
+}
+
+// remote 从配置源加载远程配置
+// 没有配置 config 时，返回空配置
+func (k *App) remote(config Map) (Map, error) {
+	uri := k.config.config
+	if vv, ok := config["config"].(string); ok {
+		uri = vv
+	}
+	if uri == "" {
+		return Map{}, nil
+	}
+
+	source, err := k.opening(uri)
+	if err != nil {
+		return nil, err
+	}
 
-	//从环境变量中读取
+	node := ConfigNode{k.config.name, k.config.role, k.config.version}
+	if vv, ok := config["name"].(string); ok {
+		node.Name = vv
+	}
+	if vv, ok := config["role"].(string); ok {
+		node.Role = vv
+	}
+	if vv, ok := config["version"].(string); ok {
+		node.Version = vv
+	}
+
+	remote, err := source.Load(node)
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %v", uri, err)
+	}
+	return remote, nil
+}
+
+// opening 按uri的scheme打开配置源，同一个uri只打开一次
+func (k *App) opening(uri string) (ConfigSource, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
 
-	// // 参数大于2个，就解析参数
-	// if len(args) > 2 {
-	// 	var name string
-	// 	var node string
-	// 	var bind string
-	// 	var key string
-	// 	var tags []string
-	// 	var join []string
+	if k.source != nil && k.sourceUri == uri {
+		return k.source, nil
+	}
+
+	scheme := "file"
+	if i := strings.Index(uri, "://"); i > 0 {
+		scheme = strings.ToLower(uri[:i])
+	}
 
-	// 	flag.StringVar(&name, "name", "chef", "cluster name")
-	// 	flag.StringVar(&node, "node", "test", "node name")
-	// 	flag.StringVar(&bind, "bind", "0.0.0.0:3000", "address to bind listeners to")
-	// 	flag.StringVar(&key, "key", "", "encryption key")
-	// 	flag.Var((*flagSlice)(&tags), "tag", "tag pair, specified as key=value")
-	// 	flag.Var((*flagSlice)(&join), "join", "address of agent to join on startup")
+	driver, ok := k.drivers[scheme]
+	if ok == false {
+		return nil, fmt.Errorf("config %s: unknown config driver %s", uri, scheme)
+	}
 
-	// 	flag.Parse()
+	source, err := driver.Open(uri)
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %v", uri, err)
+	}
+
+	k.source = source
+	k.sourceUri = uri
+	return source, nil
+}
 
-	// }
+// watching 监听配置源的变化，有变化时重载配置
+// 直到 stopper 关闭
+func (k *App) watching() {
+	k.mutex.RLock()
+	source := k.source
+	k.mutex.RUnlock()
 
-	//这里要连接集群
+	if source == nil {
+		return
+	}
 
-	// core.cluster.connect()
+	node := ConfigNode{k.config.name, k.config.role, k.config.version}
+	go func() {
+		err := source.Watch(node, func(remote Map, err error) {
+			if err != nil {
+				k.Warning("config watch failed", Map{"error": err.Error()})
+				return
+			}
+			config, err := k.loading(k.arguments(), remote)
+			if err == nil {
+				err = k.reload(config)
+			}
+			if err != nil {
+				k.Error("config reload failed", Map{"error": err.Error()})
+			}
+		}, k.stopper)
+		if err != nil {
+			k.Error("config watch failed", Map{"error": err.Error()})
+		}
+	}()
 }
 
 // identify 声明当前节点的身份和版本
@@ -189,26 +512,41 @@ func (k *chef) parse() {
 // version 编译的版本，建议每次发布时更新版本
 // 通常在一个项目中会有多个不同模块（角色），每个模块可能会运行N个节点
 // 在集群中标明当前节点的身份和版本，方便管理集群
-func (k *chef) identify(role string, versions ...string) {
+func (k *App) identify(role string, versions ...string) {
 	k.config.role = role
 	if len(versions) > 0 {
 		k.config.version = versions[0]
 	}
 }
 
+// defaulting 记录代码中设置的默认配置，然后下发
+// 已经初始化以后，按新的默认配置重新加载
+func (k *App) defaulting(config Map) {
+	k.mutex.Lock()
+	if k.defaults == nil {
+		k.defaults = Map{}
+	}
+	mergeMap(k.defaults, cloneMap(config))
+	k.mutex.Unlock()
+
+	if k.initialized || k.launched {
+		if err := k.reload(nil); err != nil {
+			k.Error("config reload failed", Map{"error": err.Error()})
+		}
+		return
+	}
+	k.configure(config)
+}
+
 // configure 为所有模块加载配置
 // 此方法有可能会被多次调用，解析文件后可调用
 // 从配置中心获取到配置后，也会调用
-func (k *chef) configure(config Map) {
+// 已经初始化以后，需要使用 reload
+func (k *App) configure(config Map) {
 	if config == nil {
 		return
 	}
 
-	// 如果已经初始化就不让修改了
-	if k.initialized || k.launched {
-		return
-	}
-
 	//注意，集群模块最先处理
 
 	//处理core中的配置
@@ -226,23 +564,35 @@ func (k *chef) configure(config Map) {
 	if version, ok := config["version"].(string); ok && version != k.config.version {
 		k.config.version = version
 	}
+	if vv, ok := config["config"].(string); ok {
+		k.config.config = vv
+	}
 	if mode, ok := config["mode"].(string); ok {
-		mode = strings.ToLower(mode)
-		if mode == "t" || mode == "test" || mode == "testing" {
-			k.config.mode = testing
-		} else if mode == "p" || mode == "prod" || mode == "production" {
-			k.config.mode = production
-		} else {
-			k.config.mode = developing
-		}
+		k.config.mode = parseMode(mode)
+	}
+
+	if shutdown := parseDurationFromMap(config, "shutdown"); shutdown >= 0 {
+		k.config.shutdown = shutdown
+	}
+	if ready := parseDurationFromMap(config, "ready"); ready >= 0 {
+		k.config.ready = ready
+	}
+	if budget, ok := config["budget"].(Map); ok {
+		k.budget(budget)
 	}
 
 	// 配置写到配置中
+	k.mutex.Lock()
 	if setting, ok := config["setting"].(Map); ok {
 		for key, val := range setting {
 			k.config.setting[key] = val
 		}
 	}
+	if k.global == nil {
+		k.global = Map{}
+	}
+	mergeMap(k.global, config)
+	k.mutex.Unlock()
 
 	// 把配置下发到各个模块
 	for _, mod := range k.modules {
@@ -250,82 +600,410 @@ func (k *chef) configure(config Map) {
 	}
 }
 
-// cluster 独立运行集群
-func (k *chef) cluster() {
-	// mCluster.Initialize()
-	// mCluster.Launch()
+// reload 运行中重新加载配置
+// config为nil时，重新从文件、环境变量、命令行参数中加载
+// 新配置合并在代码中的默认配置之上，作为完整的配置，配置中删除的key也会删除
+// name, role, version 运行中不会修改，setting先校验，再交给实现了Reloader的模块校验
+// 有模块拒绝时，setting、程序和模块的配置都保持不变
+// 全部通过以后，才让模块应用新配置，再整体替换setting和程序的配置
+// 最后把有变化的setting路径通知给 SettingWatcher
+func (k *App) reload(config Map) error {
+	if config == nil {
+		vv, err := k.loading(k.arguments(), nil)
+		if err != nil {
+			return err
+		}
+		config = vv
+	}
+
+	k.mutex.RLock()
+	candidate := mergeMap(mergeMap(Map{}, cloneMap(k.defaults)), cloneMap(config))
+	modules := k.modules
+	k.mutex.RUnlock()
+
+	// 新的setting先校验，不通过的整个重载都拒绝
+	setting := Map{}
+	if vv, ok := candidate["setting"].(Map); ok {
+		setting = vv
+	}
+	k.mutex.RLock()
+	setting, err := k.validate(setting)
+	k.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	rejects := []string{}
+	reloaders := []Reloader{}
+	for _, mod := range modules {
+		if reloader, ok := mod.(Reloader); ok {
+			if err := reloader.Verify(candidate); err != nil {
+				failure := &Failure{moduleName(mod), phaseReload, err, false}
+				rejects = append(rejects, failure.Error())
+			}
+			reloaders = append(reloaders, reloader)
+		}
+	}
+	if len(rejects) > 0 {
+		return errors.New(strings.Join(rejects, "; "))
+	}
+
+	// 都通过了才应用，不会有模块只用了一半的新配置
+	for _, reloader := range reloaders {
+		reloader.Reload(candidate)
+	}
+
+	k.mutex.Lock()
+	changes := diffKeys(k.config.setting, setting, "")
+	k.config.setting = setting
+
+	if mode, ok := candidate["mode"].(string); ok {
+		k.config.mode = parseMode(mode)
+	}
+	if shutdown := parseDurationFromMap(candidate, "shutdown"); shutdown >= 0 {
+		k.config.shutdown = shutdown
+	}
+	if ready := parseDurationFromMap(candidate, "ready"); ready >= 0 {
+		k.config.ready = ready
+	}
+	if budget, ok := candidate["budget"].(Map); ok {
+		k.budget(budget)
+	}
+
+	// 完整配置整体替换
+	k.global = candidate
+
+	watchers := k.watchers
+	k.mutex.Unlock()
+
+	if len(changes) > 0 {
+		for _, watcher := range watchers {
+			watcher(changes)
+		}
+	}
+	return nil
+}
+
+// arrange 按依赖关系给模块排序
+// 没有依赖关系的模块，保持注册时的顺序
+// 依赖的模块不存在，或是有循环依赖时返回错误
+func (k *App) arrange() error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	named := make(map[string]Module, 0)
+	for _, mod := range k.modules {
+		if vv, ok := mod.(Named); ok {
+			name := vv.Name()
+			if _, ok := named[name]; ok {
+				return fmt.Errorf("module %s registered more than once", name)
+			}
+			named[name] = mod
+		}
+	}
+
+	for _, mod := range k.modules {
+		for _, dep := range moduleDepends(mod) {
+			if _, ok := named[dep]; ok == false {
+				return fmt.Errorf("module %s depends on missing module %s", moduleName(mod), dep)
+			}
+		}
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	states := make([]int, len(k.modules))
+	indexes := make(map[string]int, 0)
+	for i, mod := range k.modules {
+		if _, ok := mod.(Named); ok {
+			indexes[moduleName(mod)] = i
+		}
+	}
+
+	modules := make([]Module, 0, len(k.modules))
+	paths := make([]string, 0)
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		mod := k.modules[i]
+		name := moduleName(mod)
+
+		switch states[i] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("module dependency cycle: %s -> %s", strings.Join(paths, " -> "), name)
+		}
+
+		states[i] = visiting
+		paths = append(paths, name)
+		for _, dep := range moduleDepends(mod) {
+			if err := visit(indexes[dep]); err != nil {
+				return err
+			}
+		}
+		paths = paths[:len(paths)-1]
+		states[i] = visited
+
+		modules = append(modules, mod)
+		return nil
+	}
+
+	for i := range k.modules {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+
+	k.modules = modules
+	return nil
 }
 
 // initialize 初始化所有模块
-func (k *chef) initialize() {
+// 初始化之前，先按依赖关系排序，之后的连接、启动、终止都使用此顺序
+// 有模块失败时，已经初始化的模块会按相反顺序终止
+func (k *App) initialize() error {
 	if k.initialized {
-		return
+		return nil
 	}
-	for _, mod := range k.modules {
-		mod.Initialize()
+	if err := k.arrange(); err != nil {
+		return err
+	}
+	if err := k.hooking(BeforeInitialize); err != nil {
+		return err
+	}
+	for i, mod := range k.modules {
+		if err := k.perform(mod, phaseInitialize); err != nil {
+			k.rollback(i)
+			return err
+		}
+	}
+	if err := k.hooking(AfterInitialize); err != nil {
+		k.rollback(len(k.modules))
+		return err
 	}
 	k.initialized = true
+	return nil
 }
 
 // connect
-func (k *chef) connect() {
+func (k *App) connect() error {
 	if k.connected {
-		return
+		return nil
+	}
+	if err := k.hooking(BeforeConnect); err != nil {
+		k.rollback(len(k.modules))
+		return err
 	}
 	for _, mod := range k.modules {
-		mod.Connect()
+		if err := k.perform(mod, phaseConnect); err != nil {
+			k.rollback(len(k.modules))
+			return err
+		}
+	}
+	if err := k.hooking(AfterConnect); err != nil {
+		k.rollback(len(k.modules))
+		return err
 	}
 	k.connected = true
+	return nil
 }
 
 // launch 启动所有模块
 // 只有部分模块是需要启动的，比如HTTP
-func (k *chef) launch() {
+func (k *App) launch() error {
 	if k.launched {
-		return
+		return nil
+	}
+	if err := k.hooking(BeforeLaunch); err != nil {
+		k.rollback(len(k.modules))
+		return err
 	}
+	readies := make([]moduleReady, 0)
 	for _, mod := range k.modules {
-		mod.Launch()
+		if awaiter, ok := mod.(Awaiter); ok {
+			ready := moduleReady{moduleName(mod), make(chan struct{}), &sync.Once{}}
+			awaiter.Await(ready.done)
+			readies = append(readies, ready)
+		}
+		if err := k.perform(mod, phaseLaunch); err != nil {
+			k.rollback(len(k.modules))
+			return err
+		}
+	}
+
+	// 等异步启动的模块都准备好，才触发启动
+	// 否则像事件监听这样的模块，会收不到启动时发出的消息
+	readies = append(readies, k.awaits()...)
+	k.awaiting(readies)
+
+	if err := k.hooking(AfterLaunch); err != nil {
+		k.rollback(len(k.modules))
+		return err
 	}
 
 	//这里是触发器，异步
-	Trigger(StartTrigger)
+	k.engine.Trigger(nil, StartTrigger, nil)
 
 	k.launched = true
 
-	if k.config.name == k.config.role || k.config.role == "" {
-		log.Println(fmt.Sprintf("%s %s-%s is running", CHEFSGO, k.config.name, k.config.version))
-	} else {
-		log.Println(fmt.Sprintf("%s %s-%s-%s is running", CHEFSGO, k.config.name, k.config.role, k.config.version))
+	k.Info("app running", Map{"name": k.config.name, "role": k.config.role, "version": k.config.version})
+
+	// 开发模式下输出启动耗时
+	k.timingReport("startup timing", phaseInitialize, phaseConnect, phaseLaunch)
+
+	// 运行以后，才开始监听配置源的变化
+	k.watching()
+
+	// 准备好了才加入集群，其它节点才能调用
+	k.joining()
+
+	return nil
+}
+
+// awaiting 等待所有模块准备好，超时的记录日志后跳过
+func (k *App) awaiting(readies []moduleReady) {
+	var timeout <-chan time.Time
+	if k.config.ready > 0 {
+		timer := time.NewTimer(k.config.ready)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for i, ready := range readies {
+		select {
+		case <-ready.ready:
+		case <-timeout:
+			names := []string{}
+			for _, vv := range readies[i:] {
+				select {
+				case <-vv.ready:
+				default:
+					names = append(names, vv.name)
+				}
+			}
+			if len(names) > 0 {
+				k.Warning("modules not ready, skipped", Map{"modules": strings.Join(names, ","), "timeout": k.config.ready.String()})
+			}
+			return
+		}
 	}
 }
 
-// waiting 等待系统退出信号
+// rollback 启动失败时，终止前count个已经初始化的模块
+// 按相反顺序终止，并重置状态
+func (k *App) rollback(count int) {
+	for i := count - 1; i >= 0; i-- {
+		k.terminating(k.modules[i])
+	}
+	k.initialized = false
+	k.connected = false
+	k.launched = false
+
+	k.logger.close()
+}
+
+// waiting 等待系统退出信号，或是 chef.Stop() 的调用
 // 为了程序做好退出前的善后工作，优雅的退出程序
-func (k *chef) waiting() {
-	// 待处理，加入自己的退出信号
-	// 并开发 chef.Stop() 给外部调用
+// 收到 SIGHUP 时不退出，而是重新加载配置
+func (k *App) waiting() {
 	waiter := make(chan os.Signal, 1)
 	signal.Notify(waiter, os.Kill, os.Interrupt, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-	<-waiter
+	defer signal.Stop(waiter)
+
+	for {
+		select {
+		case sig := <-waiter:
+			if sig == syscall.SIGHUP {
+				if err := k.reload(nil); err != nil {
+					k.Error("config reload failed", Map{"error": err.Error()})
+				}
+				continue
+			}
+			return
+		case <-k.stopper:
+			return
+		}
+	}
+}
+
+// stop 通知 waiting 结束等待，可重复调用
+func (k *App) stop() {
+	k.stopping.Do(func() {
+		close(k.stopper)
+	})
 }
 
 // terminate 终止结束所有模块
 // 终止顺序需要和初始化顺序相反以保证各模块依赖
-func (k *chef) terminate() {
+// 触发器和每个模块的终止都有超时，卡住的会记录日志后跳过
+func (k *App) terminate() {
+	// 只终止一次，Go 退出以后再调用 Terminate 不会重复终止
+	k.terminated.Do(func() {
+		// 通知配置源等后台任务结束
+		k.stop()
+
+		// 先离开集群，不再接收其它节点的调用
+		k.leaving()
+
+		if err := k.hooking(BeforeTerminate); err != nil {
+			k.Error("hook failed", Map{"error": err.Error()})
+		}
 
-	//停止前触发器，同步
-	Execute(StopTrigger)
+		//停止前触发器，同步
+		if !k.runTimeout(k.config.shutdown, func() { k.engine.Execute(nil, StopTrigger, nil) }) {
+			k.Warning("trigger timeout, skipped", Map{"trigger": StopTrigger})
+		}
 
-	for i := len(k.modules) - 1; i >= 0; i-- {
-		mod := k.modules[i]
-		mod.Terminate()
+		for i := len(k.modules) - 1; i >= 0; i-- {
+			k.terminating(k.modules[i])
+		}
+
+		// 模块都终止了，才关闭总线，终止过程中还可以调用其它节点
+		k.unbus()
+		k.launched = false
+
+		if err := k.hooking(AfterTerminate); err != nil {
+			k.Error("hook failed", Map{"error": err.Error()})
+		}
+
+		k.timingReport("shutdown timing", phaseTerminate)
+
+		k.Info("app stopped", Map{"name": k.config.name, "role": k.config.role, "version": k.config.version})
+
+		// 最后关闭日志，保证退出过程中的日志都已经写入
+		k.logger.close()
+
+		close(k.stopped)
+	})
+}
+
+// runTimeout 执行方法，并等待完成
+// 超时返回false，为0表示一直等待，方法中的panic会被记录下来
+func (k *App) runTimeout(timeout time.Duration, fn func()) bool {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if err := recover(); err != nil {
+				k.Error("panic", Map{"panic": fmt.Sprintf("%v", err)})
+			}
+		}()
+		fn()
+	}()
+
+	if timeout <= 0 {
+		<-done
+		return true
 	}
-	k.launched = false
 
-	if k.config.name == k.config.role || k.config.role == "" {
-		log.Println(fmt.Sprintf("%s %s-%s stopted", CHEFSGO, k.config.name, k.config.version))
-	} else {
-		log.Println(fmt.Sprintf("%s %s-%s-%s stopted", CHEFSGO, k.config.name, k.config.role, k.config.version))
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
 	}
 }
@@ -0,0 +1,240 @@
+// Package cheftest 测试辅助
+// 每个测试使用一个全新的程序，注册的方法、类型、编解码器互不影响
+// 不读取工作目录中的配置文件，测试结束时自动终止所有模块
+// 需要加入集群、通过总线调用的测试，用 cheftest.Go 在后台运行
+//
+//	func TestHello(t *testing.T) {
+//		app := cheftest.New(t, "hello", chef.Method{...})
+//		data, res := app.Invoke("hello", Map{"name": "chef"}, cheftest.Caller{Language: "zh", Authed: true})
+//		app.AssertOK(res)
+//	}
+package cheftest
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/chefsgo/base"
+	"github.com/chefsgo/chef"
+)
+
+type (
+	// Tester 测试用的程序
+	Tester struct {
+		*chef.App
+		t testing.TB
+	}
+
+	// Caller 模拟的调用方，用来生成Meta
+	Caller struct {
+		// Language 语言，为空时使用默认语言
+		Language string
+		// Timezone 时区，为nil时使用本地时区
+		Timezone *time.Location
+		// Trace 追踪ID
+		Trace string
+		// Signed 是否带上token，签名需要注册 json 和 text 编解码器
+		Signed bool
+		// Authed 是否已通过验证，为true时自动带上token
+		Authed bool
+		// Payload token携带的负载
+		Payload Map
+		// Expiry token的有效期，为0表示不过期
+		Expiry time.Duration
+	}
+)
+
+// New 创建一个测试用的程序，并准备好各模块
+// fixtures 为需要注册的内容，如 "hello", chef.Method{...}, "name", chef.Type{...}
+// 配置只使用代码中的，运行模式为测试，测试结束时自动终止
+func New(t testing.TB, fixtures ...Any) *Tester {
+	t.Helper()
+
+	app := prepare(t, fixtures...)
+	if err := app.Ready(); err != nil {
+		t.Fatalf("cheftest ready: %v", err)
+	}
+	t.Cleanup(app.Terminate)
+
+	return &Tester{app, t}
+}
+
+// Go 和 New 一样，但是在后台运行，启动完成后才返回
+// 需要加入集群、接收其它节点调用的测试使用，测试结束时自动停止
+func Go(t testing.TB, fixtures ...Any) *Tester {
+	t.Helper()
+
+	app := prepare(t, fixtures...)
+	launched := make(chan struct{})
+	app.Register("cheftest.launched", chef.Hook{Phase: chef.AfterLaunch, Action: func() {
+		close(launched)
+	}})
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- app.Go()
+	}()
+	select {
+	case <-launched:
+	case err := <-errs:
+		t.Fatalf("cheftest go: %v", err)
+	}
+	t.Cleanup(func() {
+		app.Stop()
+		<-app.Done()
+	})
+
+	return &Tester{app, t}
+}
+
+// prepare 创建程序，注册 fixtures
+func prepare(t testing.TB, fixtures ...Any) *chef.App {
+	t.Helper()
+
+	app := chef.New()
+	app.Isolate()
+	app.Configure(Map{"mode": "testing"})
+
+	// 按 name, value 成对注册，Map，Module 等不需要名称的可以直接传
+	name := ""
+	for _, fixture := range fixtures {
+		if vv, ok := fixture.(string); ok {
+			if name != "" {
+				t.Fatalf("cheftest fixture %s: missing value", name)
+			}
+			name = vv
+			continue
+		}
+		if name != "" {
+			app.Register(name, fixture)
+		} else {
+			app.Register(fixture)
+		}
+		name = ""
+	}
+	if name != "" {
+		t.Fatalf("cheftest fixture %s: missing value", name)
+	}
+	return app
+}
+
+// Meta 按调用方生成一个Meta，不传时为匿名调用
+func (tester *Tester) Meta(callers ...Caller) *chef.Meta {
+	tester.t.Helper()
+
+	meta := tester.App.Meta()
+	if len(callers) == 0 {
+		return meta
+	}
+
+	caller := callers[0]
+	if caller.Language != "" {
+		meta.Language(caller.Language)
+	}
+	if caller.Timezone != nil {
+		meta.Timezone(caller.Timezone)
+	}
+	if caller.Trace != "" {
+		meta.Trace(caller.Trace)
+	}
+	if caller.Signed || caller.Authed {
+		ends := []time.Duration{}
+		if caller.Expiry > 0 {
+			ends = append(ends, caller.Expiry)
+		}
+		if token := meta.Sign(caller.Authed, caller.Payload, ends...); token == "" {
+			tester.t.Fatalf("cheftest sign: %s", resState(meta.Result()))
+		}
+	}
+	return meta
+}
+
+// Invoke 以调用方的身份调用方法，返回数据和结果
+func (tester *Tester) Invoke(name string, value Map, callers ...Caller) (Map, Res) {
+	tester.t.Helper()
+	meta := tester.Meta(callers...)
+	defer chef.CloseMeta(meta)
+
+	data := meta.Invoke(name, value)
+	return data, meta.Result()
+}
+
+// Invokes 以调用方的身份调用方法，返回列表和结果
+func (tester *Tester) Invokes(name string, value Map, callers ...Caller) ([]Map, Res) {
+	tester.t.Helper()
+	meta := tester.Meta(callers...)
+	defer chef.CloseMeta(meta)
+
+	items := meta.Invokes(name, value)
+	return items, meta.Result()
+}
+
+// Mapping 按定义解析参数，返回解析后的值和结果
+func (tester *Tester) Mapping(config Vars, data Map) (Map, Res) {
+	value := Map{}
+	res := tester.App.Mapping(config, data, value, false, false)
+	return value, res
+}
+
+// AssertOK 断言结果成功，nil 也算成功
+func (tester *Tester) AssertOK(res Res) {
+	tester.t.Helper()
+	if res != nil && res.Fail() {
+		tester.t.Errorf("expected ok, got %s(%d): %s", res.State(), res.Code(), tester.text(res))
+	}
+}
+
+// AssertFail 断言结果失败
+func (tester *Tester) AssertFail(res Res) {
+	tester.t.Helper()
+	if res == nil || res.OK() {
+		tester.t.Errorf("expected fail, got ok")
+	}
+}
+
+// AssertCode 断言结果的状态码
+func (tester *Tester) AssertCode(res Res, code int) {
+	tester.t.Helper()
+	if actual := resCode(res); actual != code {
+		tester.t.Errorf("expected code %d, got %d", code, actual)
+	}
+}
+
+// AssertState 断言结果的状态
+// 也可以传入用 chef.Result 定义的结果，如 chef.Invalid
+func (tester *Tester) AssertState(res Res, state Any) {
+	tester.t.Helper()
+
+	expected := ""
+	switch vv := state.(type) {
+	case string:
+		expected = vv
+	case Res:
+		expected = resState(vv)
+	}
+
+	if actual := resState(res); actual != expected {
+		tester.t.Errorf("expected state %s, got %s", expected, actual)
+	}
+}
+
+// text 获取结果的默认语言文案
+func (tester *Tester) text(res Res) string {
+	return tester.App.String(chef.DEFAULT, res.State(), res.Args()...)
+}
+
+// resCode nil 当成功处理
+func resCode(res Res) int {
+	if res == nil {
+		return chef.OK.Code()
+	}
+	return res.Code()
+}
+
+// resState nil 当成功处理
+func resState(res Res) string {
+	if res == nil {
+		return chef.OK.State()
+	}
+	return res.State()
+}
@@ -0,0 +1,135 @@
+package cheftest_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	. "github.com/chefsgo/base"
+	"github.com/chefsgo/chef"
+	"github.com/chefsgo/chef/cheftest"
+)
+
+// codecs 签名需要的编解码器
+var codecs = []Any{
+	chef.JSON, chef.Codec{
+		Encode: func(v Any) (Any, error) {
+			return json.Marshal(v)
+		},
+		Decode: func(d Any, v Any) (Any, error) {
+			return v, json.Unmarshal(d.([]byte), v)
+		},
+	},
+	chef.TEXT, chef.Codec{
+		Encode: func(v Any) (Any, error) {
+			return base64.RawURLEncoding.EncodeToString([]byte(v.(string))), nil
+		},
+		Decode: func(d Any, v Any) (Any, error) {
+			bts, err := base64.RawURLEncoding.DecodeString(d.(string))
+			return string(bts), err
+		},
+	},
+}
+
+// stringType 参数用的字串类型
+var stringType = chef.Type{
+	Valid: func(value Any, config Var) bool {
+		_, ok := value.(string)
+		return ok
+	},
+	Value: func(value Any, config Var) Any {
+		return value
+	},
+}
+
+func TestInvoke(t *testing.T) {
+	app := cheftest.New(t, append(codecs, "string", stringType, "hello", chef.Method{
+		Args: Vars{
+			"name": Var{Type: "string", Required: true},
+		},
+		Action: func(ctx *chef.Context) (Map, Res) {
+			if ctx.Authed() == false {
+				return nil, chef.Unauthed
+			}
+			return Map{"hello": ctx.Args["name"], "lang": ctx.Language()}, nil
+		},
+	})...)
+
+	data, res := app.Invoke("hello", Map{"name": "chef"}, cheftest.Caller{Language: "zh", Authed: true})
+	app.AssertOK(res)
+	if data["hello"] != "chef" || data["lang"] != "zh" {
+		t.Errorf("unexpected data %v", data)
+	}
+
+	_, res = app.Invoke("hello", Map{"name": "chef"})
+	app.AssertState(res, chef.Unauthed)
+
+	_, res = app.Invoke("hello", Map{})
+	app.AssertFail(res)
+
+	_, res = app.Invoke("missing", nil)
+	app.AssertState(res, chef.Nothing)
+}
+
+func TestIsolated(t *testing.T) {
+	first := cheftest.New(t, "hello", chef.Method{
+		Action: func(ctx *chef.Context) Map {
+			return Map{"ok": true}
+		},
+	})
+	second := cheftest.New(t)
+
+	_, res := first.Invoke("hello", nil)
+	first.AssertOK(res)
+
+	_, res = second.Invoke("hello", nil)
+	second.AssertState(res, chef.Nothing)
+}
+
+func TestCodec(t *testing.T) {
+	reverse := func(v Any) (Any, error) {
+		text, ok := v.(string)
+		if ok == false {
+			return nil, errors.New("not string")
+		}
+		runes := []rune(text)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return string(runes), nil
+	}
+
+	app := cheftest.New(t, "string", stringType, "reverse", chef.Codec{
+		Encode: reverse,
+		Decode: func(d Any, v Any) (Any, error) {
+			return reverse(d)
+		},
+	}, "code", chef.Method{
+		Args: Vars{
+			"code": Var{Type: "string", Required: true, Decode: "reverse"},
+		},
+		Action: func(ctx *chef.Context) Map {
+			return Map{"code": ctx.Args["code"]}
+		},
+	})
+
+	// 参数按编解码器解密
+	data, res := app.Invoke("code", Map{"code": "olleh"})
+	app.AssertOK(res)
+	if data["code"] != "hello" {
+		t.Errorf("expected decoded hello, got %v", data["code"])
+	}
+
+	// 数据按编解码器加密
+	value, res := app.Mapping(Vars{"code": Var{Type: "string", Encode: "reverse"}}, Map{"code": "hello"})
+	app.AssertOK(res)
+	if value["code"] != "olleh" {
+		t.Errorf("expected encoded olleh, got %v", value["code"])
+	}
+
+	// 编解码器只注册在当前的程序
+	if _, err := cheftest.New(t).Encrypt("reverse", "hello"); err == nil {
+		t.Error("expected codec not found in another app")
+	}
+}
@@ -0,0 +1,486 @@
+package chef
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/chefsgo/base"
+)
+
+// 节点变化的事件
+const (
+	NodeJoin   = "join"
+	NodeUpdate = "update"
+	NodeLeave  = "leave"
+)
+
+type (
+	// Node 集群中的节点
+	Node struct {
+		// Id 节点ID，每次启动都不一样
+		Id      string `json:"id"`
+		Name    string `json:"name"`
+		Role    string `json:"role"`
+		Version string `json:"version"`
+		Address string `json:"address"`
+		// Methods 节点提供的服务，只有 Service 会通告，Method 只在本节点调用
+		Methods []string `json:"methods"`
+		// Time 最后一次通告的时间
+		Time time.Time `json:"time"`
+	}
+
+	// NodeWatcher 集群节点变化的回调
+	// event 为 join, update, leave
+	NodeWatcher func(event string, node Node)
+
+	// Cluster 集群成员
+	Cluster interface {
+		// Announce 通告当前节点，会定时调用，相当于心跳
+		Announce(node Node) error
+		// Nodes 获取集群中所有存活的节点
+		Nodes() ([]Node, error)
+		// Leave 离开集群
+		Leave(node Node) error
+	}
+
+	// ClusterDriver 集群驱动
+	// 按配置中 cluster.driver 选择驱动，config 为 cluster 配置节
+	ClusterDriver interface {
+		Open(config Map) (Cluster, error)
+	}
+
+	clusterConfig struct {
+		// Driver 集群驱动，为空表示不加入集群
+		Driver string
+		// Address 当前节点对外的地址
+		Address string
+		// Interval 通告和刷新的间隔
+		Interval time.Duration
+	}
+
+	// clustering 集群的运行状态
+	clustering struct {
+		mutex   sync.RWMutex
+		config  clusterConfig
+		drivers map[string]ClusterDriver
+		cluster Cluster
+		self    Node
+		nodes   map[string]Node
+		joined  bool
+
+		watchers []NodeWatcher
+	}
+
+	// staticClusterDriver 静态节点列表
+	staticClusterDriver struct{}
+	// staticCluster 节点从配置的 cluster.peers 读取，不需要通告
+	staticCluster struct {
+		peers []Node
+	}
+
+	// fileClusterDriver 共享目录集群驱动
+	fileClusterDriver struct{}
+	// fileCluster 每个节点定时在共享目录中写入自己的信息
+	// 超过 expiry 没有更新的节点，视为已经离开
+	fileCluster struct {
+		dir    string
+		expiry time.Duration
+	}
+)
+
+// nodeId 生成节点ID
+func nodeId() string {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// nodeKey 节点的唯一标识，静态节点没有ID时使用地址
+func nodeKey(node Node) string {
+	if node.Id != "" {
+		return node.Id
+	}
+	if node.Address != "" {
+		return node.Address
+	}
+	return node.Name + "-" + node.Role
+}
+
+// nodeChanged 节点信息是否有变化，不比较时间
+func nodeChanged(old, node Node) bool {
+	return old.Name != node.Name || old.Role != node.Role || old.Version != node.Version ||
+		old.Address != node.Address || strings.Join(old.Methods, ",") != strings.Join(node.Methods, ",")
+}
+
+// parseNodes 从配置中解析节点列表
+func parseNodes(value Any) []Node {
+	nodes := make([]Node, 0)
+
+	configs := []Map{}
+	switch vv := value.(type) {
+	case []Map:
+		configs = vv
+	case []Any:
+		for _, v := range vv {
+			if config, ok := v.(Map); ok {
+				configs = append(configs, config)
+			}
+		}
+	}
+
+	for _, config := range configs {
+		node := Node{}
+		node.Id, _ = config["id"].(string)
+		node.Name, _ = config["name"].(string)
+		node.Role, _ = config["role"].(string)
+		node.Version, _ = config["version"].(string)
+		node.Address, _ = config["address"].(string)
+
+		switch vv := config["methods"].(type) {
+		case []string:
+			node.Methods = vv
+		case []Any:
+			for _, v := range vv {
+				node.Methods = append(node.Methods, fmt.Sprintf("%v", v))
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+//------------------------- app ----------------------------
+
+// clusterDriver 注册集群驱动
+func (k *App) clusterDriver(name string, driver ClusterDriver, override bool) {
+	k.clustering.mutex.Lock()
+	defer k.clustering.mutex.Unlock()
+
+	if k.clustering.drivers == nil {
+		k.clustering.drivers = make(map[string]ClusterDriver, 0)
+	}
+	if override {
+		k.clustering.drivers[name] = driver
+	} else {
+		if _, ok := k.clustering.drivers[name]; ok == false {
+			k.clustering.drivers[name] = driver
+		}
+	}
+}
+
+// nodeWatcher 注册节点变化的回调
+func (k *App) nodeWatcher(watcher NodeWatcher) {
+	k.clustering.mutex.Lock()
+	defer k.clustering.mutex.Unlock()
+	k.clustering.watchers = append(k.clustering.watchers, watcher)
+}
+
+// node 当前节点的信息
+func (k *App) node() Node {
+	k.clustering.mutex.RLock()
+	self := k.clustering.self
+	self.Address = k.clustering.config.Address
+	k.clustering.mutex.RUnlock()
+
+	// 没有配置地址时，使用总线的地址，其它节点才能调用
+	if self.Address == "" {
+		self.Address = k.busAddress()
+	}
+
+	self.Name = k.config.name
+	self.Role = k.config.role
+	self.Version = k.config.version
+	self.Methods = k.engine.services()
+	self.Time = time.Now()
+	return self
+}
+
+// cluster 打开集群驱动，并加载一次节点列表
+// 没有配置 cluster.driver 时，只有当前节点
+func (k *App) cluster() error {
+	k.mutex.RLock()
+	config, _ := k.global["cluster"].(Map)
+	k.mutex.RUnlock()
+
+	cfg := clusterConfig{Interval: time.Second * 5}
+	cfg.Driver, _ = config["driver"].(string)
+	cfg.Address, _ = config["address"].(string)
+	if interval := parseDurationFromMap(config, "interval"); interval > 0 {
+		cfg.Interval = interval
+	}
+
+	k.clustering.mutex.Lock()
+	k.clustering.config = cfg
+	driver, ok := k.clustering.drivers[cfg.Driver]
+	k.clustering.mutex.Unlock()
+
+	if cfg.Driver == "" {
+		return nil
+	}
+	if ok == false {
+		return fmt.Errorf("cluster unknown driver %s", cfg.Driver)
+	}
+
+	cluster, err := driver.Open(config)
+	if err != nil {
+		return fmt.Errorf("cluster %s: %v", cfg.Driver, err)
+	}
+
+	k.clustering.mutex.Lock()
+	k.clustering.cluster = cluster
+	k.clustering.mutex.Unlock()
+
+	return k.refresh()
+}
+
+// refresh 刷新节点列表，有变化时通知
+func (k *App) refresh() error {
+	k.clustering.mutex.RLock()
+	cluster := k.clustering.cluster
+	k.clustering.mutex.RUnlock()
+
+	self := k.node()
+	nodes := map[string]Node{nodeKey(self): self}
+
+	if cluster != nil {
+		vvs, err := cluster.Nodes()
+		if err != nil {
+			return err
+		}
+		for _, node := range vvs {
+			// 静态列表中可能包括自己
+			if node.Id == "" && node.Address != "" && node.Address == self.Address {
+				continue
+			}
+			if _, ok := nodes[nodeKey(node)]; ok {
+				continue
+			}
+			nodes[nodeKey(node)] = node
+		}
+	}
+
+	type change struct {
+		event string
+		node  Node
+	}
+	changes := []change{}
+
+	k.clustering.mutex.Lock()
+	for key, node := range nodes {
+		if old, ok := k.clustering.nodes[key]; ok == false {
+			changes = append(changes, change{NodeJoin, node})
+		} else if nodeChanged(old, node) {
+			changes = append(changes, change{NodeUpdate, node})
+		}
+	}
+	for key, node := range k.clustering.nodes {
+		if _, ok := nodes[key]; ok == false {
+			changes = append(changes, change{NodeLeave, node})
+		}
+	}
+	k.clustering.nodes = nodes
+	watchers := k.clustering.watchers
+	k.clustering.mutex.Unlock()
+
+	for _, change := range changes {
+		for _, watcher := range watchers {
+			watcher(change.event, change.node)
+		}
+	}
+	return nil
+}
+
+// joining 加入集群，定时通告自己并刷新节点列表，直到 stopper 关闭
+func (k *App) joining() {
+	k.clustering.mutex.Lock()
+	cluster := k.clustering.cluster
+	interval := k.clustering.config.Interval
+	if cluster == nil || k.clustering.joined {
+		k.clustering.mutex.Unlock()
+		return
+	}
+	k.clustering.joined = true
+	k.clustering.mutex.Unlock()
+
+	announce := func() {
+		if err := cluster.Announce(k.node()); err != nil {
+			k.Warning("cluster announce failed", Map{"error": err.Error()})
+		}
+		if err := k.refresh(); err != nil {
+			k.Warning("cluster refresh failed", Map{"error": err.Error()})
+		}
+	}
+	announce()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-k.stopper:
+				return
+			case <-ticker.C:
+				announce()
+			}
+		}
+	}()
+}
+
+// leaving 离开集群
+func (k *App) leaving() {
+	k.clustering.mutex.Lock()
+	cluster := k.clustering.cluster
+	joined := k.clustering.joined
+	k.clustering.joined = false
+	k.clustering.mutex.Unlock()
+
+	if cluster == nil || joined == false {
+		return
+	}
+	if err := cluster.Leave(k.node()); err != nil {
+		k.Warning("cluster leave failed", Map{"error": err.Error()})
+	}
+}
+
+// Nodes 获取集群中所有的节点，包括当前节点
+// 按角色和名称排序
+func (k *App) Nodes() []Node {
+	k.clustering.mutex.RLock()
+	nodes := make([]Node, 0, len(k.clustering.nodes))
+	for _, node := range k.clustering.nodes {
+		nodes = append(nodes, node)
+	}
+	k.clustering.mutex.RUnlock()
+
+	if len(nodes) == 0 {
+		nodes = append(nodes, k.node())
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Role != nodes[j].Role {
+			return nodes[i].Role < nodes[j].Role
+		}
+		if nodes[i].Name != nodes[j].Name {
+			return nodes[i].Name < nodes[j].Name
+		}
+		return nodes[i].Address < nodes[j].Address
+	})
+	return nodes
+}
+
+// Nodes 获取集群中所有的节点，包括当前节点
+func Nodes() []Node {
+	return core.Nodes()
+}
+
+//------------------------- static ----------------------------
+
+func (driver *staticClusterDriver) Open(config Map) (Cluster, error) {
+	return &staticCluster{parseNodes(config["peers"])}, nil
+}
+
+func (cluster *staticCluster) Announce(node Node) error {
+	return nil
+}
+
+func (cluster *staticCluster) Nodes() ([]Node, error) {
+	nodes := make([]Node, len(cluster.peers))
+	copy(nodes, cluster.peers)
+	return nodes, nil
+}
+
+func (cluster *staticCluster) Leave(node Node) error {
+	return nil
+}
+
+//------------------------- file ----------------------------
+
+func (driver *fileClusterDriver) Open(config Map) (Cluster, error) {
+	dir, _ := config["dir"].(string)
+	if dir == "" {
+		return nil, fmt.Errorf("cluster.dir missing")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	expiry := parseDurationFromMap(config, "expiry")
+	if expiry <= 0 {
+		expiry = time.Second * 15
+		if interval := parseDurationFromMap(config, "interval"); interval > 0 {
+			expiry = interval * 3
+		}
+	}
+
+	return &fileCluster{dir, expiry}, nil
+}
+
+// file 节点对应的文件
+func (cluster *fileCluster) file(node Node) string {
+	return path.Join(cluster.dir, node.Id+".json")
+}
+
+// Announce 写入节点信息，先写临时文件再改名，避免读到写了一半的文件
+func (cluster *fileCluster) Announce(node Node) error {
+	bytes, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+
+	file := cluster.file(node)
+	temp := file + ".tmp"
+	if err := ioutil.WriteFile(temp, bytes, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp, file)
+}
+
+// Nodes 读取目录中所有没有过期的节点
+func (cluster *fileCluster) Nodes() ([]Node, error) {
+	files, err := ioutil.ReadDir(cluster.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]Node, 0)
+	for _, info := range files {
+		if info.IsDir() || path.Ext(info.Name()) != ".json" {
+			continue
+		}
+
+		bytes, err := ioutil.ReadFile(path.Join(cluster.dir, info.Name()))
+		if err != nil {
+			continue
+		}
+		node := Node{}
+		if err := json.Unmarshal(bytes, &node); err != nil {
+			continue
+		}
+		if time.Since(node.Time) > cluster.expiry {
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// Leave 删除节点文件
+func (cluster *fileCluster) Leave(node Node) error {
+	err := os.Remove(cluster.file(node))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
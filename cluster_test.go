@@ -0,0 +1,135 @@
+package chef_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/chefsgo/base"
+	"github.com/chefsgo/chef"
+	"github.com/chefsgo/chef/cheftest"
+)
+
+func TestFileCluster(t *testing.T) {
+	dir := t.TempDir()
+
+	mutex := sync.Mutex{}
+	events := map[string][]string{}
+
+	start := func(role string) *cheftest.Tester {
+		return cheftest.Go(t, Map{
+			"role":    role,
+			"cluster": Map{"driver": "file", "dir": dir, "interval": "50ms"},
+		}, func(event string, node chef.Node) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			events[event] = append(events[event], node.Role)
+		})
+	}
+
+	// 等待条件成立，超时就失败
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for cond() == false {
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	counted := func(event, role string) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, vv := range events[event] {
+			if vv == role {
+				return true
+			}
+		}
+		return false
+	}
+
+	first := start("first")
+	second := start("second")
+
+	waitFor("both nodes", func() bool {
+		return len(first.Nodes()) == 2 && len(second.Nodes()) == 2
+	})
+
+	nodes := first.Nodes()
+	if nodes[0].Role != "first" || nodes[1].Role != "second" {
+		t.Errorf("unexpected nodes %v", nodes)
+	}
+	if nodes[0].Id == nodes[1].Id {
+		t.Errorf("expected different node ids, got %s", nodes[0].Id)
+	}
+	waitFor("join events", func() bool {
+		return counted(chef.NodeJoin, "first") && counted(chef.NodeJoin, "second")
+	})
+
+	second.Stop()
+	<-second.Done()
+
+	waitFor("leave event", func() bool {
+		return counted(chef.NodeLeave, "second")
+	})
+	if nodes := first.Nodes(); len(nodes) != 1 || nodes[0].Role != "first" {
+		t.Errorf("expected only first node, got %v", nodes)
+	}
+}
+
+func TestStaticCluster(t *testing.T) {
+	// 先占一个端口，拿到地址再释放，静态列表需要事先知道地址
+	address := func() string {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		return listener.Addr().String()
+	}
+	addresses := map[string]string{"first": address(), "second": address()}
+
+	peers := []Map{}
+	for _, role := range []string{"first", "second"} {
+		peers = append(peers, Map{
+			"role": role, "address": "tcp://" + addresses[role], "methods": []string{role + ".hello"},
+		})
+	}
+
+	// 两个程序用同一份静态列表，列表中的自己会被跳过
+	start := func(role string) *cheftest.Tester {
+		return cheftest.New(t, Map{
+			"role":    role,
+			"bus":     Map{"driver": "tcp", "address": addresses[role]},
+			"cluster": Map{"driver": "static", "peers": peers},
+		}, role+".hello", chef.Service{
+			Action: func(ctx *chef.Context) Map {
+				return Map{"from": role}
+			},
+		})
+	}
+	first := start("first")
+	second := start("second")
+
+	for _, app := range []*cheftest.Tester{first, second} {
+		nodes := app.Nodes()
+		if len(nodes) != 2 || nodes[0].Role != "first" || nodes[1].Role != "second" {
+			t.Errorf("%s: unexpected nodes %v", app.Role(), nodes)
+		}
+	}
+
+	// 通过静态列表中的地址调用对方的服务
+	data, res := first.Invoke("second.hello", nil)
+	first.AssertOK(res)
+	if data["from"] != "second" {
+		t.Errorf("expected data from second, got %v", data)
+	}
+	data, res = second.Invoke("first.hello", nil)
+	second.AssertOK(res)
+	if data["from"] != "first" {
+		t.Errorf("expected data from first, got %v", data)
+	}
+}
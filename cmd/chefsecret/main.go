@@ -0,0 +1,35 @@
+// chefsecret 离线加密配置值
+// 生成的 ENC(...) 可以直接写到配置文件中，运行时用同一个密钥解密
+//
+//	CHEF_SECRET_KEY=xxx chefsecret value1 value2
+//	echo value | CHEF_SECRET_KEY=xxx chefsecret
+//
+// 不带参数时从标准输入按行读取，避免明文留在命令历史中
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/chefsgo/chef"
+)
+
+func main() {
+	values := os.Args[1:]
+	if len(values) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			values = append(values, scanner.Text())
+		}
+	}
+
+	for _, value := range values {
+		secret, err := chef.Secret(value)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(secret)
+	}
+}
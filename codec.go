@@ -11,8 +11,8 @@ import (
 	"github.com/chefsgo/util"
 )
 
-var (
-	mCodec = &codecModule{
+func newCodecModule() *codecModule {
+	return &codecModule{
 		config: codecConfig{
 			Text:  "01234AaBbCcDdEeFfGgHhIiJjKkLlMmNnOoPpQqRrSsTtUuVvWwXxYyZz56789-_",
 			Digit: "abcdefghijkmnpqrstuvwxyz123456789ACDEFGHJKLMNPQRSTUVWXYZ",
@@ -24,6 +24,9 @@ var (
 		},
 		codecs: make(map[string]Codec, 0),
 	}
+}
+
+var (
 	errInvalidCodec     = errors.New("Invalid codec.")
 	errInvalidCodecData = errors.New("Invalid codec data.")
 )
@@ -88,6 +91,11 @@ type (
 	}
 )
 
+// Name 模块名称
+func (module *codecModule) Name() string {
+	return "codec"
+}
+
 // Builtin
 func (module *codecModule) Builtin() {
 
@@ -99,7 +107,7 @@ func (module *codecModule) Register(name string, value Any, override bool) {
 	case Codec:
 		module.Codec(name, val, override)
 		// case Crypto:
-		// 	mCodec.Crypto(key, val, overrides...)
+		// 	module.Crypto(key, val, overrides...)
 	}
 
 	// fmt.Println("codec registered", name)
@@ -114,57 +122,57 @@ func (module *codecModule) Configure(global Map) {
 
 	//字串字符表
 	if text, ok := config["text"].(string); ok {
-		mCodec.config.Text = text
+		module.config.Text = text
 	}
 
 	//数字字母表
 	if digit, ok := config["digit"].(string); ok {
-		mCodec.config.Digit = digit
+		module.config.Digit = digit
 	}
 	if salt, ok := config["salt"].(string); ok {
-		mCodec.config.Salt = salt
+		module.config.Salt = salt
 	}
 	if length, ok := config["length"].(int64); ok {
-		mCodec.config.Length = int(length)
+		module.config.Length = int(length)
 	}
 	if length, ok := config["length"].(int); ok {
-		mCodec.config.Length = int(length)
+		module.config.Length = int(length)
 	}
 
 	//雪花相关配置
 
 	//开始时间
 	if vv, ok := config["start"].(time.Time); ok {
-		mCodec.config.Start = vv
+		module.config.Start = vv
 	}
 	if vv, ok := config["start"].(int64); ok {
-		mCodec.config.Start = time.Unix(vv, 0)
+		module.config.Start = time.Unix(vv, 0)
 	}
 	//时间位
 	if vv, ok := config["timebits"].(int); ok {
-		mCodec.config.Timebits = uint(vv)
+		module.config.Timebits = uint(vv)
 	}
 	if vv, ok := config["timebits"].(int64); ok {
-		mCodec.config.Timebits = uint(vv)
+		module.config.Timebits = uint(vv)
 	}
 	//节点位
 	if vv, ok := config["nodebits"].(int); ok {
-		mCodec.config.Nodebits = uint(vv)
+		module.config.Nodebits = uint(vv)
 	}
 	if vv, ok := config["nodebits"].(int64); ok {
-		mCodec.config.Nodebits = uint(vv)
+		module.config.Nodebits = uint(vv)
 	}
 	//序列位
 	if vv, ok := config["stepbits"].(int); ok {
-		mCodec.config.Stepbits = uint(vv)
+		module.config.Stepbits = uint(vv)
 	}
 	if vv, ok := config["stepbits"].(int64); ok {
-		mCodec.config.Stepbits = uint(vv)
+		module.config.Stepbits = uint(vv)
 	}
 }
 
 func (module *codecModule) Initialize() {
-	mCodec.fastid = util.NewFastID(mCodec.config.Timebits, mCodec.config.Nodebits, mCodec.config.Stepbits, mCodec.config.Start.Unix())
+	module.fastid = util.NewFastID(module.config.Timebits, module.config.Nodebits, module.config.Stepbits, module.config.Start.Unix())
 	// fmt.Println("codec initialized")
 }
 func (module *codecModule) Connect() {
@@ -210,12 +218,12 @@ func (this *codecModule) Codecs() map[string]Codec {
 
 // Sequence 雪花ID
 func (module *codecModule) Sequence() int64 {
-	return mCodec.fastid.NextID()
+	return module.fastid.NextID()
 }
 
 // Unique 雪花ID 转数字加密
 func (module *codecModule) Generate(prefixs ...string) string {
-	id := mCodec.Sequence()
+	id := module.Sequence()
 	ss, err := module.EncryptDIGIT(id)
 	if err != nil {
 		return fmt.Sprintf("%v", id)
@@ -230,7 +238,7 @@ func (module *codecModule) Generate(prefixs ...string) string {
 // Encode 原始的编码
 func (module *codecModule) Encode(codec string, v Any) (Any, error) {
 	codec = strings.ToLower(codec)
-	if ccc, ok := mCodec.codecs[codec]; ok {
+	if ccc, ok := module.codecs[codec]; ok {
 		return ccc.Encode(v)
 	}
 	return nil, errInvalidCodec
@@ -239,7 +247,7 @@ func (module *codecModule) Encode(codec string, v Any) (Any, error) {
 // Decode 原始的解码
 func (module *codecModule) Decode(codec string, d Any, v Any) (Any, error) {
 	codec = strings.ToLower(codec)
-	if ccc, ok := mCodec.codecs[codec]; ok {
+	if ccc, ok := module.codecs[codec]; ok {
 		return ccc.Decode(d, v)
 	}
 	return nil, errInvalidCodec
@@ -248,7 +256,7 @@ func (module *codecModule) Decode(codec string, d Any, v Any) (Any, error) {
 // Marshal 序列化
 // 如 json, xml, gob 等
 func (module *codecModule) Marshal(codec string, v Any) ([]byte, error) {
-	dat, err := mCodec.Encode(codec, v)
+	dat, err := module.Encode(codec, v)
 	if err != nil {
 		return nil, err
 	}
@@ -262,7 +270,7 @@ func (module *codecModule) Marshal(codec string, v Any) ([]byte, error) {
 // Unmarshal 反序列化
 // 如 json, xml, gob 等
 func (module *codecModule) Unmarshal(codec string, d []byte, v Any) error {
-	_, err := mCodec.Decode(codec, d, v)
+	_, err := module.Decode(codec, d, v)
 	return err
 }
 
@@ -270,7 +278,7 @@ func (module *codecModule) Unmarshal(codec string, d []byte, v Any) error {
 // 主要用类Var中的参数，数据
 // 数据加密后，要返回明文可读的字串，方便传递
 func (module *codecModule) Encrypt(codec string, v Any) (string, error) {
-	dat, err := mCodec.Encode(codec, v)
+	dat, err := module.Encode(codec, v)
 	if err != nil {
 		return "", err
 	}
@@ -287,62 +295,62 @@ func (module *codecModule) Encrypt(codec string, v Any) (string, error) {
 // Decrypt 数据解密
 // 主要用类Var中的参数，数据
 func (module *codecModule) Decrypt(codec string, v Any) (Any, error) {
-	return mCodec.Decode(codec, v, nil)
+	return module.Decode(codec, v, nil)
 }
 
 // MarshalJSON
 func (module *codecModule) MarshalJSON(v Any) ([]byte, error) {
-	return mCodec.Marshal(JSON, v)
+	return module.Marshal(JSON, v)
 }
 
 // UnmarshalJSON
 func (module *codecModule) UnmarshalJSON(d []byte, v Any) error {
-	return mCodec.Unmarshal(JSON, d, v)
+	return module.Unmarshal(JSON, d, v)
 }
 
 // MarshalXML
 func (module *codecModule) MarshalXML(v Any) ([]byte, error) {
-	return mCodec.Marshal(XML, v)
+	return module.Marshal(XML, v)
 }
 
 // XMLUnmarshal
 func (module *codecModule) UnmarshalXML(d []byte, v Any) error {
-	return mCodec.Unmarshal(XML, d, v)
+	return module.Unmarshal(XML, d, v)
 }
 
 // GOBMarshal
 func (module *codecModule) MarshalGOB(v Any) ([]byte, error) {
-	return mCodec.Marshal(GOB, v)
+	return module.Marshal(GOB, v)
 }
 
 // UnmarshalGOB
 func (module *codecModule) UnmarshalGOB(d []byte, v Any) error {
-	return mCodec.Unmarshal(GOB, d, v)
+	return module.Unmarshal(GOB, d, v)
 }
 
 // MarshalTOML
 func (module *codecModule) MarshalTOML(v Any) ([]byte, error) {
-	return mCodec.Marshal(TOML, v)
+	return module.Marshal(TOML, v)
 }
 
 // UnmarshalTOML
 func (module *codecModule) UnmarshalTOML(d []byte, v Any) error {
-	return mCodec.Unmarshal(TOML, d, v)
+	return module.Unmarshal(TOML, d, v)
 }
 
 // EncryptDIGIT
 func (module *codecModule) EncryptDIGIT(n int64) (string, error) {
-	return mCodec.Encrypt(DIGIT, n)
+	return module.Encrypt(DIGIT, n)
 }
 
 // DigitsEncrypt alias for DigitsEncrypt
 func (module *codecModule) EncryptDIGITS(ns []int64) (string, error) {
-	return mCodec.Encrypt(DIGITS, ns)
+	return module.Encrypt(DIGITS, ns)
 }
 
 // DecryptDigit 解码数字
 func (module *codecModule) DecryptDIGIT(s string) (int64, error) {
-	val, err := mCodec.Decrypt(DIGIT, s)
+	val, err := module.Decrypt(DIGIT, s)
 	if err != nil {
 		return -1, err
 	}
@@ -359,7 +367,7 @@ func (module *codecModule) DecryptDIGIT(s string) (int64, error) {
 
 // DecryptDigits 解码数字数组
 func (module *codecModule) DecryptDIGITS(s string) ([]int64, error) {
-	val, err := mCodec.Decrypt(DIGIT, s)
+	val, err := module.Decrypt(DIGIT, s)
 	if err != nil {
 		return nil, err
 	}
@@ -379,17 +387,17 @@ func (module *codecModule) DecryptDIGITS(s string) ([]int64, error) {
 
 // EncryptTEXT 加密文本
 func (module *codecModule) EncryptTEXT(n string) (string, error) {
-	return mCodec.Encrypt(TEXT, n)
+	return module.Encrypt(TEXT, n)
 }
 
 // TextsEncrypt 文本数组加密
 func (module *codecModule) EncryptTEXTS(ns []string) (string, error) {
-	return mCodec.Encrypt(TEXTS, ns)
+	return module.Encrypt(TEXTS, ns)
 }
 
 // DecryptTEXT 解码文本
 func (module *codecModule) DecryptTEXT(s string) (string, error) {
-	val, err := mCodec.Decrypt(TEXT, s)
+	val, err := module.Decrypt(TEXT, s)
 	if err != nil {
 		return "", err
 	}
@@ -403,7 +411,7 @@ func (module *codecModule) DecryptTEXT(s string) (string, error) {
 
 // DecryptTEXTS 解码文本数组
 func (module *codecModule) DecryptTEXTS(s string) ([]string, error) {
-	val, err := mCodec.Decrypt(TEXT, s)
+	val, err := module.Decrypt(TEXT, s)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,239 @@
+package chef
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	. "github.com/chefsgo/base"
+)
+
+var (
+	// builtinCommands 内置的子命令，注册同名的命令可以替换
+	builtinCommands = map[string]Command{
+		"version":  {Name: "version", Text: "输出名称、角色和版本", Action: versionCommand},
+		"check":    {Name: "check", Text: "加载并校验配置，然后退出", Action: checkCommand},
+		"methods":  {Name: "methods", Text: "以JSON输出所有方法", Action: methodsCommand},
+		"services": {Name: "services", Text: "以JSON输出可以被其它节点调用的服务", Action: servicesCommand},
+		"types":    {Name: "types", Text: "以JSON输出所有类型", Action: typesCommand},
+		"states":   {Name: "states", Text: "以JSON输出所有状态", Action: statesCommand},
+		"task":     {Name: "task", Text: "运行任务，如 task name --arg=value", Action: taskCommand},
+		"run":      {Name: "run", Text: "正常运行，和不带子命令一样"},
+	}
+)
+
+type (
+	// Command 子命令，如 app version, app check config.toml
+	// 用 Register("name", Command{...}) 注册
+	// 不是子命令的第一个参数，仍然当作配置文件
+	Command struct {
+		Name string
+		Text string
+		// Action 执行命令，args 为子命令之后的参数，返回退出码
+		// 为nil表示正常运行程序
+		Action func(app *App, args []string) int
+	}
+
+	// ExitError 子命令返回了不为0的退出码
+	// 由调用方决定是否按 Code 退出进程
+	ExitError struct {
+		Command string
+		Code    int
+	}
+)
+
+// Error 符合error接口
+func (err *ExitError) Error() string {
+	return fmt.Sprintf("command %s exit %d", err.Command, err.Code)
+}
+
+// command 注册子命令
+func (k *App) command(name string, config Command, override bool) {
+	if config.Name == "" {
+		config.Name = name
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.commands == nil {
+		k.commands = make(map[string]Command, 0)
+	}
+	if override {
+		k.commands[name] = config
+	} else {
+		if _, ok := k.commands[name]; ok == false {
+			k.commands[name] = config
+		}
+	}
+}
+
+// commanding 从参数中解析子命令
+// 返回子命令，以及去掉子命令以后的参数，第一个参数仍然是程序名
+func (k *App) commanding(args []string) (*Command, []string) {
+	if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+		return nil, args
+	}
+
+	name := args[1]
+
+	k.mutex.RLock()
+	command, ok := k.commands[name]
+	k.mutex.RUnlock()
+
+	if ok == false {
+		if command, ok = builtinCommands[name]; ok == false {
+			return nil, args
+		}
+	}
+
+	rest := append([]string{args[0]}, args[2:]...)
+	return &command, rest
+}
+
+// arguments 启动参数，有子命令时已经去掉了子命令
+// 程序自己不读取 os.Args，只有包级别的 chef.Go 和 chef.Ready 会传入
+func (k *App) arguments() []string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return k.args
+}
+
+// arguing 设置启动参数，已经设置过的不再覆盖
+func (k *App) arguing(args []string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.args == nil {
+		k.args = args
+	}
+}
+
+// dispatch 执行子命令
+// 返回是否已经处理，没有处理的需要正常运行
+// 子命令的退出码不为0时，返回 *ExitError
+func (k *App) dispatch(args []string) (bool, error) {
+	command, args := k.commanding(args)
+
+	k.mutex.Lock()
+	k.args = args
+	k.mutex.Unlock()
+
+	if command == nil || command.Action == nil {
+		return false, nil
+	}
+	if code := command.Action(k, args[1:]); code != 0 {
+		return true, &ExitError{Command: command.Name, Code: code}
+	}
+	return true, nil
+}
+
+// output 以JSON格式输出到标准输出
+func output(value Any) int {
+	bytes, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(bytes))
+	return 0
+}
+
+// varsOutput 参数定义转成可以输出的格式，去掉方法
+func varsOutput(vars Vars) Map {
+	if vars == nil {
+		return nil
+	}
+
+	values := Map{}
+	for key, config := range vars {
+		value := Map{
+			"type": config.Type, "required": config.Required, "nullable": config.Nullable,
+			"name": config.Name, "text": config.Text,
+		}
+		if config.Default != nil && reflect.TypeOf(config.Default).Kind() != reflect.Func {
+			value["default"] = config.Default
+		}
+		if config.Children != nil {
+			value["children"] = varsOutput(config.Children)
+		}
+		values[key] = value
+	}
+	return values
+}
+
+// versionCommand 输出名称、角色和版本
+func versionCommand(app *App, args []string) int {
+	fmt.Println(app.config.name, app.config.role, app.config.version)
+	return 0
+}
+
+// checkCommand 加载并校验配置，检查模块依赖，不初始化模块
+func checkCommand(app *App, args []string) int {
+	if err := app.parse(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := app.arrange(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println("config ok")
+	return 0
+}
+
+// methodsCommand 输出所有方法
+func methodsCommand(app *App, args []string) int {
+	return output(methodsOutput(app, false))
+}
+
+// servicesCommand 只输出服务
+func servicesCommand(app *App, args []string) int {
+	return output(methodsOutput(app, true))
+}
+
+// methodsOutput 方法转成可以输出的格式
+func methodsOutput(app *App, services bool) Map {
+	app.engine.mutex.Lock()
+	defer app.engine.mutex.Unlock()
+
+	methods := Map{}
+	for name, config := range app.engine.methods {
+		if services && config.service == false {
+			continue
+		}
+		methods[name] = Map{
+			"name": config.Name, "text": config.Text, "nullable": config.Nullable,
+			"args": varsOutput(config.Args), "data": varsOutput(config.Data),
+			"token": config.Token, "auth": config.Auth, "claims": config.Claims, "scopes": config.Scopes,
+			"service": config.service,
+		}
+	}
+	return methods
+}
+
+// typesCommand 输出所有类型
+func typesCommand(app *App, args []string) int {
+	types := Map{}
+	for name, config := range app.basic.Types() {
+		types[name] = Map{"name": config.Name, "text": config.Text, "alias": config.Alias}
+	}
+	return output(types)
+}
+
+// statesCommand 输出所有状态和默认文案
+func statesCommand(app *App, args []string) int {
+	app.basic.mutex.Lock()
+	codes := States{}
+	for name, code := range app.basic.states {
+		codes[name] = code
+	}
+	app.basic.mutex.Unlock()
+
+	states := Map{}
+	for name, code := range codes {
+		states[name] = Map{"code": int(code), "text": app.basic.String(DEFAULT, name)}
+	}
+	return output(states)
+}
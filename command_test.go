@@ -0,0 +1,106 @@
+package chef_test
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/chefsgo/base"
+	"github.com/chefsgo/chef"
+)
+
+func TestRunCommand(t *testing.T) {
+	app := chef.New()
+	app.Isolate()
+
+	called := []string{}
+	app.Register("hello", chef.Command{
+		Action: func(app *chef.App, args []string) int {
+			called = args
+			return 0
+		},
+	})
+	app.Register("fail", chef.Command{
+		Action: func(app *chef.App, args []string) int {
+			return 3
+		},
+	})
+
+	if err := app.Run([]string{"app", "hello", "a", "b"}); err != nil {
+		t.Fatalf("hello: %v", err)
+	}
+	if len(called) != 2 || called[0] != "a" || called[1] != "b" {
+		t.Errorf("unexpected args %v", called)
+	}
+
+	err := app.Run([]string{"app", "fail"})
+	exit := &chef.ExitError{}
+	if errors.As(err, &exit) == false {
+		t.Fatalf("expected *ExitError, got %v", err)
+	}
+	if exit.Command != "fail" || exit.Code != 3 {
+		t.Errorf("unexpected exit %+v", exit)
+	}
+}
+
+func TestGoIgnoresArgs(t *testing.T) {
+	app := chef.New()
+	app.Isolate()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- app.Go("api")
+	}()
+
+	app.Stop()
+	if err := <-done; err != nil {
+		t.Fatalf("go: %v", err)
+	}
+	if app.Role() != "api" {
+		t.Errorf("expected role api, got %s", app.Role())
+	}
+}
+
+func TestRunTask(t *testing.T) {
+	app := chef.New()
+	app.Isolate()
+
+	readied := false
+	app.Register("ready", chef.Hook{Phase: chef.BeforeInitialize, Action: func() {
+		readied = true
+	}})
+
+	// 内置类型在其它包中，这里只需要原样返回
+	for _, name := range []string{"string", "bool"} {
+		app.Register(name, chef.Type{
+			Valid: func(value Any, config Var) bool { return true },
+			Value: func(value Any, config Var) Any { return value },
+		})
+	}
+
+	value := Map{}
+	app.Register("import", chef.Task{
+		Args: Vars{
+			"file":  Var{Type: "string"},
+			"force": Var{Type: "bool"},
+		},
+		Action: func(ctx *chef.Context) {
+			value = ctx.Value
+		},
+	})
+
+	err := app.Run([]string{"app", "task", "missing"})
+	exit := &chef.ExitError{}
+	if errors.As(err, &exit) == false || exit.Code != 1 {
+		t.Fatalf("expected exit code 1, got %v", err)
+	}
+	if readied {
+		t.Errorf("missing task should not ready the app")
+	}
+
+	if err := app.Run([]string{"app", "task", "import", "--force", "extra", "--file", "a.csv", "--dry"}); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if value["force"] != "true" || value["file"] != "a.csv" || value["dry"] != "true" {
+		t.Errorf("unexpected value %v", value)
+	}
+}
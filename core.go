@@ -1,24 +1,74 @@
 package chef
 
 import (
+	"time"
+
 	. "github.com/chefsgo/base"
 )
 
 var (
-	core = &chef{
+	// core 默认的程序，包级别的方法都是操作它
+	core = New()
+
+	mBasic  = core.basic
+	mCodec  = core.codec
+	mToken  = core.token
+	mEngine = core.engine
+	mHealth = core.health
+)
+
+// New 创建一个新的程序
+// 每个程序有自己的模块、注册表和配置，可以在同一个进程中运行多个
+// 用 Result 定义的状态和字串会自动带上，其它的内容需要在程序上自己注册
+func New() *App {
+	app := &App{
 		parsed:      false,
 		initialized: false,
 		connected:   false,
 		launched:    false,
 		config: config{
 			name: CHEF, role: CHEF, version: "v0.0.0",
+			shutdown: time.Second * 10, ready: time.Second * 30,
 			setting: Map{},
 		},
 
 		modules: make([]Module, 0),
+		stopper: make(chan struct{}),
+		stopped: make(chan struct{}),
+		drivers: make(map[string]ConfigDriver, 0),
 	}
-)
 
-func init() {
+	app.logger = newLoggerModule(app)
+	app.basic = newBasicModule(app)
+	app.codec = newCodecModule()
+	app.token = newTokenModule(app)
+	app.engine = newEngineModule(app)
+	app.health = newHealthModule(app)
+
+	// 内置模块，日志最先初始化，最后终止
+	app.loader(app.logger)
+	app.loader(app.basic)
+	app.loader(app.codec)
+	app.loader(app.token)
+	app.loader(app.engine)
+	app.loader(app.health)
+
+	app.codec.Codec(AEAD, aeadCodec, true)
+
+	app.driver("file", &fileConfigDriver{}, true)
+	app.driver("http", &httpConfigDriver{}, true)
+	app.driver("https", &httpConfigDriver{}, true)
+
+	app.clusterDriver("static", &staticClusterDriver{}, true)
+	app.clusterDriver("file", &fileClusterDriver{}, true)
+	app.clustering.self.Id = nodeId()
+
+	app.busDriver("loopback", &loopbackBusDriver{}, true)
+	app.busDriver("tcp", &socketBusDriver{app, "tcp"}, true)
+	app.busDriver("unix", &socketBusDriver{app, "unix"}, true)
+
+	// 已经定义过的状态和字串
+	app.basic.results(definedResults())
 
+	return app
 }
@@ -1,17 +1,16 @@
 package chef
 
 import (
+	"sort"
 	"sync"
+	"time"
 
 	. "github.com/chefsgo/base"
 )
 
-var (
-	mEngine = newEngineModule()
-)
-
-func newEngineModule() *engineModule {
+func newEngineModule(app *App) *engineModule {
 	return &engineModule{
+		app:     app,
 		methods: make(map[string]Method, 0),
 	}
 }
@@ -45,8 +44,19 @@ type (
 
 		Token bool `json:"token"`
 		Auth  bool `json:"auth"`
+		// Claims 需要token负载中带有的字段，值为nil时只要求有此字段，否则需要相等
+		Claims Map `json:"claims,omitempty"`
+		// Scopes 需要token负载的 scopes 中全部包含
+		Scopes []string `json:"scopes,omitempty"`
+		// Timeout 超时时间，为0表示不限制，调用时可以用 Meta.Timeout 覆盖
+		Timeout time.Duration `json:"timeout,omitempty"`
+
+		// Interceptors 只对此方法生效的拦截器，在全局和Logic的拦截器之后执行
+		Interceptors []Interceptor `json:"-"`
 	}
 
+	// Service 服务，和 Method 一样注册到方法表中
+	// 只有服务可以被其它节点调用，Method 只在本节点内调用
 	Service struct {
 		Name     string   `json:"name"`
 		Text     string   `json:"desc"`
@@ -58,8 +68,13 @@ type (
 		Coding   bool     `json:"-"`
 		Action   Any      `json:"-"`
 
-		Token bool `json:"token"`
-		Auth  bool `json:"auth"`
+		Token   bool          `json:"token"`
+		Auth    bool          `json:"auth"`
+		Claims  Map           `json:"claims,omitempty"`
+		Scopes  []string      `json:"scopes,omitempty"`
+		Timeout time.Duration `json:"timeout,omitempty"`
+
+		Interceptors []Interceptor `json:"-"`
 	}
 
 	Context struct {
@@ -81,11 +96,24 @@ type (
 	}
 
 	engineModule struct {
+		app     *App
 		mutex   sync.Mutex
 		methods map[string]Method
+
+		interceptors []Interceptor
 	}
 )
 
+// Name 模块名称
+func (module *engineModule) Name() string {
+	return "engine"
+}
+
+// Depends 参数处理依赖basic和codec
+func (module *engineModule) Depends() []string {
+	return []string{"basic", "codec"}
+}
+
 // Builtin
 func (module *engineModule) Builtin() {
 
@@ -96,9 +124,10 @@ func (module *engineModule) Register(key string, value Any, override bool) {
 	switch val := value.(type) {
 	case Method:
 		module.Method(key, val, override)
-		//待处理，服务
-		// case Service:
-		// 	module.Service(key, val, override)
+	case Service:
+		module.Service(key, val, override)
+	case Interceptor:
+		module.Interceptor(key, val, override)
 	}
 }
 
@@ -145,32 +174,65 @@ func (module *engineModule) Method(name string, config Method, override bool) {
 	}
 }
 
+// Service 注册服务，转成带服务标记的方法
 func (module *engineModule) Service(name string, config Service, override bool) {
-	// method := Method{
-	// 	config.Name, config.Text, config.Alias, config.Nullable,
-	// 	config.Args, config.Data, config.Setting, config.Coding, config.Action,
-	// 	config.Token, config.Auth,
-	// }
+	method := Method{
+		service: true,
+		Name:    config.Name, Text: config.Text, Alias: config.Alias, Nullable: config.Nullable,
+		Args: config.Args, Data: config.Data, Setting: config.Setting, Coding: config.Coding, Action: config.Action,
+		Token: config.Token, Auth: config.Auth, Claims: config.Claims, Scopes: config.Scopes,
+		Timeout: config.Timeout, Interceptors: config.Interceptors,
+	}
+	module.Method(name, method, override)
 }
 
-//给本地 invoke 的，加上远程调用
-func (module *engineModule) Call(meta *Meta, name string, value Map, settings ...Map) (Map, Res, string) {
-	data, callRes, tttt := module.call(meta, name, value, settings...)
+// services 所有服务的名称，包括别名
+func (module *engineModule) services() []string {
+	module.mutex.Lock()
+	defer module.mutex.Unlock()
 
-	if callRes == Nothing {
-		//待处理，远程调用
-		// //本地不存在的时候，去总线请求
-		// res, err := mBus.Request(ctx, name, value, time.Second*5)
-		// if err != nil {
-		// 	return nil, errorResult(err), tttt
-		// }
+	names := make([]string, 0)
+	for name, config := range module.methods {
+		if config.service {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// method 查找本地的方法
+func (module *engineModule) method(name string) (Method, bool) {
+	module.mutex.Lock()
+	defer module.mutex.Unlock()
+	config, ok := module.methods[name]
+	return config, ok
+}
 
-		// //直接返因，因为mBus.Request已经处理args和data
-		// return res.Data, newResult(res.Code, res.Text), res.Type
+// serve 给其它节点调用的入口，只能调用服务
+func (module *engineModule) serve(meta *Meta, name string, value Map, settings ...Map) (Map, Res, string) {
+	config, ok := module.method(name)
+	if ok == false || config.service == false {
+		return nil, Nothing, engineInvoke
+	}
+	return module.local(meta, name, value, settings...)
+}
 
-		return data, callRes, tttt
+//给本地 invoke 的，加上远程调用
+func (module *engineModule) Call(meta *Meta, name string, value Map, settings ...Map) (Map, Res, string) {
+	//本地不存在的时候，去总线请求提供此服务的节点
+	//参数和返回数据，已经在对方节点处理过了
+	//本地方法自己返回的 Nothing 不转发
+	if _, ok := module.method(name); ok == false {
+		return module.app.forward(meta, name, value)
+	}
+	return module.local(meta, name, value, settings...)
+}
 
-	} else if callRes == Retry {
+// local 本地调用，本地和其它节点调用过来的结果要一致
+func (module *engineModule) local(meta *Meta, name string, value Map, settings ...Map) (Map, Res, string) {
+	data, callRes, tttt := module.call(meta, name, value, settings...)
+	if callRes == Retry {
 		//待优化：非队列环境下的Retry直接改为失败
 		return data, Fail, tttt
 	} else {
@@ -182,11 +244,15 @@ func (module *engineModule) Call(meta *Meta, name string, value Map, settings ..
 //此方法不能远程调用，要不然就死循环了
 func (module *engineModule) call(meta *Meta, name string, value Map, settings ...Map) (Map, Res, string) {
 	tttt := engineInvoke
-	if _, ok := module.methods[name]; ok == false {
+	config, ok := module.method(name)
+	if ok == false {
 		return nil, Nothing, tttt
 	}
 
-	config := module.methods[name]
+	// 直接执行的时候没有meta
+	if meta == nil {
+		meta = &Meta{app: module.app}
+	}
 
 	ctx := &Context{Meta: meta}
 	ctx.Name = name
@@ -202,30 +268,49 @@ func (module *engineModule) call(meta *Meta, name string, value Map, settings ..
 		}
 	}
 
-	// 待处理
-
-	//处理token
-	// if config.Token && ctx.token == "" {
-	// 	return nil, Unauthorized, tttt
-	// }
-
-	// if config.Auth && false == ctx.Authorized() {
-	// 	return nil, Unauthorized, tttt
-	// }
-
 	if value == nil {
 		value = Map{}
 	}
+	ctx.Value = value
+
+	interceptors := module.intercepting(name, config)
+	invoke := func() (Map, Res, string) {
+		callType := tttt
+		// 拦截器都执行完，最里面才是方法本身
+		// 授权也在拦截器之后，这样拦截器可以看到被拒绝的调用
+		data, result := intercept(ctx, interceptors, func() (Map, Res) {
+			if res := authorize(ctx.Meta, config); res != nil {
+				return nil, res
+			}
+			data, result, tttt := module.action(ctx)
+			callType = tttt
+			return data, result
+		})
+		return data, result, callType
+	}
+
+	// 调用时指定的超时优先，嵌套调用不会超过上级的期限
+	timeout := config.Timeout
+	if meta.timeout > 0 {
+		timeout = meta.timeout
+	}
+	return module.limit(ctx, timeout, invoke)
+}
+
+// action 解析参数，执行方法，并处理返回的数据
+func (module *engineModule) action(ctx *Context) (Map, Res, string) {
+	tttt := engineInvoke
+	config := ctx.Config
+	value := ctx.Value
 
 	args := Map{}
 	if config.Args != nil {
-		res := mBasic.Mapping(config.Args, value, args, config.Nullable, false, ctx.Timezone())
+		res := module.app.basic.Mapping(config.Args, value, args, config.Nullable, false, ctx.Timezone())
 		if res != nil && res.Fail() {
 			return nil, res, tttt
 		}
 	}
 
-	ctx.Value = value
 	ctx.Args = args
 
 	// process := &Process{
@@ -315,7 +400,7 @@ func (module *engineModule) call(meta *Meta, name string, value Map, settings ..
 	//参数解析
 	if config.Data != nil {
 		out := Map{}
-		err := mBasic.Mapping(config.Data, data, out, false, false, ctx.Timezone())
+		err := module.app.basic.Mapping(config.Data, data, out, false, false, ctx.Timezone())
 		if err == nil || err.OK() {
 			return out, result, tttt
 		}
@@ -325,13 +410,20 @@ func (module *engineModule) call(meta *Meta, name string, value Map, settings ..
 	return data, result, tttt
 }
 
+// Execute 只执行本地的方法，不转发到其它节点
 func (module *engineModule) Execute(meta *Meta, name string, value Map, settings ...Map) (Map, Res) {
-	m, r, _ := module.Call(meta, name, value, settings...)
+	m, r, _ := module.local(meta, name, value, settings...)
 	return m, r
 }
 
+// Trigger 异步执行本地的方法，也不转发
 func (module *engineModule) Trigger(meta *Meta, name string, value Map, settings ...Map) {
-	go module.Call(meta, name, value, settings...)
+	trigger, cancel := module.triggering(meta)
+	go func() {
+		defer cancel()
+		defer trigger.close()
+		module.local(trigger, name, value, settings...)
+	}()
 }
 
 //以下几个方法要做些交叉处理
@@ -616,27 +708,20 @@ func invokerDataConfig() Vars {
 
 //方法参数
 func Arguments(name string, extends ...Vars) Vars {
-	return mEngine.Arguments(name, extends...)
+	return core.Arguments(name, extends...)
+}
+
+// Services 所有服务的名称，只有服务可以被其它节点调用
+func Services() []string {
+	return core.Services()
 }
 
 //直接执行，同步，本地
 func Execute(name string, values ...Any) (Map, Res) {
-	var value Map
-	if len(values) > 0 {
-		if vv, ok := values[0].(Map); ok {
-			value = vv
-		}
-	}
-	return mEngine.Execute(nil, name, value)
+	return core.Execute(name, values...)
 }
 
 //触发执行，异步，本地
 func Trigger(name string, values ...Any) {
-	var value Map
-	if len(values) > 0 {
-		if vv, ok := values[0].(Map); ok {
-			value = vv
-		}
-	}
-	mEngine.Trigger(nil, name, value)
+	core.Trigger(name, values...)
 }
@@ -2,3 +2,7 @@ module github.com/chefsgo/chef
 
 go 1.17
 
+require (
+	github.com/BurntSushi/toml v1.6.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,289 @@
+package chef
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/chefsgo/base"
+)
+
+func newHealthModule(app *App) *healthModule {
+	return &healthModule{
+		app: app,
+		config: healthConfig{
+			Interval: time.Second * 10, Timeout: time.Second * 5,
+		},
+		healths:  make(map[string]HealthState, 0),
+		reports:  make(map[string]HealthState, 0),
+		watchers: make([]HealthWatcher, 0),
+	}
+}
+
+const (
+	HealthUp       = "up"
+	HealthDown     = "down"
+	HealthDegraded = "degraded"
+)
+
+type (
+	// Checker 模块健康检查，可选实现
+	// 实现以后，health模块会定时调用，汇总到 chef.Health()
+	Checker interface {
+		Health() HealthState
+	}
+
+	// HealthState 单个模块的健康状态
+	HealthState struct {
+		// Status 状态，up, down, degraded
+		Status string `json:"status"`
+		// Latency 检查耗时，模块没有设置时，自动计时
+		Latency time.Duration `json:"latency"`
+		// Details 详细信息，由模块自己定义
+		Details Map `json:"details,omitempty"`
+	}
+
+	// Healths 汇总的健康状态
+	Healths struct {
+		// Status 总状态，有down的为down，有degraded的为degraded，否则为up
+		Status string `json:"status"`
+		// Time 最后检查时间
+		Time time.Time `json:"time"`
+		// Modules 各模块的健康状态
+		Modules map[string]HealthState `json:"modules"`
+	}
+
+	// HealthWatcher 健康状态变化的回调
+	// 模块的状态有变化时调用，name 为模块名称
+	HealthWatcher func(name string, health HealthState)
+
+	healthConfig struct {
+		// Interval 后台检查的间隔，为0表示不在后台检查
+		Interval time.Duration
+		// Timeout 单个模块检查的超时时间
+		Timeout time.Duration
+	}
+
+	healthModule struct {
+		app    *App
+		mutex  sync.RWMutex
+		config healthConfig
+
+		probed   time.Time
+		healths  map[string]HealthState
+		watchers []HealthWatcher
+		// reports 模块通过句柄上报的状态
+		reports map[string]HealthState
+
+		stopper chan struct{}
+	}
+)
+
+// Name 模块名称
+func (module *healthModule) Name() string {
+	return "health"
+}
+
+// Register
+func (module *healthModule) Register(name string, value Any, override bool) {
+	switch val := value.(type) {
+	case HealthWatcher:
+		module.Watcher(val)
+	case func(name string, health HealthState):
+		module.Watcher(val)
+	}
+}
+
+// Configure
+func (module *healthModule) Configure(global Map) {
+	var config Map
+	if vv, ok := global["health"].(Map); ok {
+		config = vv
+	}
+
+	if interval := parseDurationFromMap(config, "interval"); interval >= 0 {
+		module.config.Interval = interval
+	}
+	if timeout := parseDurationFromMap(config, "timeout"); timeout >= 0 {
+		module.config.Timeout = timeout
+	}
+}
+
+// Initialize
+func (module *healthModule) Initialize() {
+}
+
+// Connect
+func (module *healthModule) Connect() {
+}
+
+// Launch 启动后台检查
+func (module *healthModule) Launch() {
+	if module.config.Interval <= 0 || module.stopper != nil {
+		return
+	}
+
+	module.stopper = make(chan struct{})
+	go module.probing(module.stopper)
+}
+
+// Terminate 停止后台检查
+func (module *healthModule) Terminate() {
+	if module.stopper != nil {
+		close(module.stopper)
+		module.stopper = nil
+	}
+}
+
+// Watcher 注册状态变化的回调
+func (module *healthModule) Watcher(watcher HealthWatcher) {
+	module.mutex.Lock()
+	defer module.mutex.Unlock()
+	module.watchers = append(module.watchers, watcher)
+}
+
+// report 模块上报健康状态，有变化时通知
+// 同时实现了Checker的模块，下次检查时以Checker的结果为准
+func (module *healthModule) report(name string, health HealthState) {
+	if health.Status == "" {
+		health.Status = HealthUp
+	}
+
+	module.mutex.Lock()
+	module.reports[name] = health
+	old, ok := module.healths[name]
+	module.healths[name] = health
+	watchers := module.watchers
+	module.mutex.Unlock()
+
+	if ok == false || old.Status != health.Status {
+		for _, watcher := range watchers {
+			watcher(name, health)
+		}
+	}
+}
+
+// probing 定时检查
+func (module *healthModule) probing(stopper chan struct{}) {
+	module.probe()
+
+	ticker := time.NewTicker(module.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopper:
+			return
+		case <-ticker.C:
+			module.probe()
+		}
+	}
+}
+
+// probe 检查所有实现了Checker的模块，并通知有变化的状态
+func (module *healthModule) probe() {
+	module.app.mutex.RLock()
+	modules := make([]Module, len(module.app.modules))
+	copy(modules, module.app.modules)
+	module.app.mutex.RUnlock()
+
+	healths := make(map[string]HealthState, 0)
+	for _, mod := range modules {
+		if checker, ok := mod.(Checker); ok {
+			healths[moduleName(mod)] = module.check(checker)
+		}
+	}
+
+	module.mutex.Lock()
+	for name, health := range module.reports {
+		if _, ok := healths[name]; ok == false {
+			healths[name] = health
+		}
+	}
+	changes := make(map[string]HealthState, 0)
+	for name, health := range healths {
+		if old, ok := module.healths[name]; !ok || old.Status != health.Status {
+			changes[name] = health
+		}
+	}
+	module.healths = healths
+	module.probed = time.Now()
+	watchers := module.watchers
+	module.mutex.Unlock()
+
+	for name, health := range changes {
+		for _, watcher := range watchers {
+			watcher(name, health)
+		}
+	}
+}
+
+// check 检查单个模块，超时或是panic都算down
+func (module *healthModule) check(checker Checker) HealthState {
+	begin := time.Now()
+	result := make(chan HealthState, 1)
+
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				result <- HealthState{Status: HealthDown, Details: Map{"error": fmt.Sprintf("%v", err)}}
+			}
+		}()
+		result <- checker.Health()
+	}()
+
+	var health HealthState
+	if module.config.Timeout > 0 {
+		select {
+		case health = <-result:
+		case <-time.After(module.config.Timeout):
+			health = HealthState{Status: HealthDown, Details: Map{"error": "timeout"}}
+		}
+	} else {
+		health = <-result
+	}
+
+	if health.Status == "" {
+		health.Status = HealthUp
+	}
+	if health.Latency == 0 {
+		health.Latency = time.Since(begin)
+	}
+	return health
+}
+
+// Healths 汇总所有模块的健康状态
+// 还没有检查过的时候，先检查一次
+func (module *healthModule) Healths() Healths {
+	module.mutex.RLock()
+	probed := module.probed
+	module.mutex.RUnlock()
+
+	if probed.IsZero() {
+		module.probe()
+	}
+
+	module.mutex.RLock()
+	defer module.mutex.RUnlock()
+
+	healths := Healths{
+		Status: HealthUp, Time: module.probed,
+		Modules: make(map[string]HealthState, 0),
+	}
+	for name, health := range module.healths {
+		healths.Modules[name] = health
+		if health.Status == HealthDown {
+			healths.Status = HealthDown
+		} else if health.Status != HealthUp && healths.Status == HealthUp {
+			healths.Status = HealthDegraded
+		}
+	}
+
+	return healths
+}
+
+// Health 获取所有模块汇总的健康状态
+// 可以用于容器的存活和就绪检查
+func Health() Healths {
+	return core.Health()
+}
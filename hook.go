@@ -0,0 +1,138 @@
+package chef
+
+import (
+	"fmt"
+	"sort"
+
+	. "github.com/chefsgo/base"
+)
+
+// 钩子的阶段
+const (
+	BeforeParse      = "before.parse"
+	AfterParse       = "after.parse"
+	BeforeConfigure  = "before.configure"
+	AfterConfigure   = "after.configure"
+	BeforeInitialize = "before.initialize"
+	AfterInitialize  = "after.initialize"
+	BeforeConnect    = "before.connect"
+	AfterConnect     = "after.connect"
+	BeforeLaunch     = "before.launch"
+	AfterLaunch      = "after.launch"
+	BeforeTerminate  = "before.terminate"
+	AfterTerminate   = "after.terminate"
+)
+
+type (
+	// Hook 生命周期钩子，在启动和退出的各个阶段前后同步执行
+	// 用 Register("name", Hook{...}) 注册，同一阶段可以注册多个
+	// 启动阶段的钩子返回错误或是panic时，会中止启动，已经初始化的模块会被终止
+	// 退出阶段的钩子出错只记录日志，不影响退出
+	Hook struct {
+		// Name 名称，出错时用于说明是哪个钩子，为空时使用注册的名称
+		Name string
+		// Text 说明
+		Text string
+		// Phase 阶段，如 chef.BeforeInitialize, chef.AfterLaunch，其它值注册时会panic
+		Phase string
+		// Order 执行顺序，小的先执行，相同的按注册顺序
+		Order int
+		// Action 执行的方法，支持以下几种
+		// func(), func() error, func(*App), func(*App) error，其它类型注册时会panic
+		Action Any
+	}
+
+	// HookFailure 钩子执行失败
+	HookFailure struct {
+		Hook  string
+		Phase string
+		Err   error
+		Panic bool
+	}
+)
+
+// Error 符合error接口
+func (failure *HookFailure) Error() string {
+	if failure.Panic {
+		return fmt.Sprintf("hook %s %s panic: %v", failure.Hook, failure.Phase, failure.Err)
+	}
+	return fmt.Sprintf("hook %s %s failed: %v", failure.Hook, failure.Phase, failure.Err)
+}
+
+// Unwrap 返回具体的错误
+func (failure *HookFailure) Unwrap() error {
+	return failure.Err
+}
+
+// hook 注册钩子
+func (k *App) hook(name string, config Hook) {
+	if config.Name == "" {
+		config.Name = name
+	}
+
+	switch config.Action.(type) {
+	case func(), func() error, func(*App), func(*App) error:
+	default:
+		panic("Invalid hook action: " + config.Name)
+	}
+
+	switch config.Phase {
+	case BeforeParse, AfterParse, BeforeConfigure, AfterConfigure,
+		BeforeInitialize, AfterInitialize, BeforeConnect, AfterConnect,
+		BeforeLaunch, AfterLaunch, BeforeTerminate, AfterTerminate:
+	default:
+		panic("Invalid hook phase: " + config.Name)
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.hooks == nil {
+		k.hooks = make(map[string][]Hook, 0)
+	}
+	k.hooks[config.Phase] = append(k.hooks[config.Phase], config)
+}
+
+// hooking 按顺序执行阶段的所有钩子，遇到错误就停止
+func (k *App) hooking(phase string) error {
+	k.mutex.RLock()
+	hooks := make([]Hook, len(k.hooks[phase]))
+	copy(hooks, k.hooks[phase])
+	k.mutex.RUnlock()
+
+	sort.SliceStable(hooks, func(i, j int) bool {
+		return hooks[i].Order < hooks[j].Order
+	})
+
+	for _, hook := range hooks {
+		if err := k.hookPerform(hook); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hookPerform 执行单个钩子，panic 也当作失败
+func (k *App) hookPerform(hook Hook) (err error) {
+	defer func() {
+		if res := recover(); res != nil {
+			err = &HookFailure{Hook: hook.Name, Phase: hook.Phase, Err: fmt.Errorf("%v", res), Panic: true}
+		}
+	}()
+
+	switch ff := hook.Action.(type) {
+	case func():
+		ff()
+	case func() error:
+		err = ff()
+	case func(*App):
+		ff(k)
+	case func(*App) error:
+		err = ff(k)
+	}
+
+	if err != nil {
+		return &HookFailure{Hook: hook.Name, Phase: hook.Phase, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package chef
+
+import (
+	"fmt"
+	"sync"
+
+	. "github.com/chefsgo/base"
+)
+
+type (
+	// Host 模块访问chef的句柄
+	// 实现了 Attacher 的模块注册时，chef 会传入属于它自己的句柄
+	// 模块通过句柄访问所属的程序，而不需要使用包级别的方法
+	Host interface {
+		// Name 模块名称
+		Name() string
+		// Config 模块自己的配置节，如 [health] 对应 health 模块
+		// 返回的是深度复制的一份
+		Config() Map
+		// Setting 程序的setting
+		Setting() Map
+		// Register 注册方法、类型、状态等到所属的程序
+		Register(regs ...Any)
+		// Log 输出带模块名称的日志
+		Log(format string, args ...Any)
+		// Stop 请求程序停止运行
+		Stop()
+		// Health 上报模块的健康状态，会汇总到程序的健康状态
+		Health(health HealthState)
+		// Await 声明模块是异步启动的，返回的 ready 在准备好以后调用
+		// 需要在 Launch 返回之前调用，程序会等待 ready 以后才触发 StartTrigger
+		Await() (ready func())
+	}
+
+	// moduleHost 模块的句柄
+	moduleHost struct {
+		app   *App
+		name  string
+		mutex sync.Mutex
+		ready *moduleReady
+	}
+)
+
+// attach 给模块创建句柄
+func (k *App) attach(mod Module) {
+	attacher, ok := mod.(Attacher)
+	if ok == false {
+		return
+	}
+
+	host := &moduleHost{app: k, name: moduleName(mod)}
+
+	k.mutex.Lock()
+	k.hosts = append(k.hosts, host)
+	k.mutex.Unlock()
+
+	attacher.Attach(host)
+}
+
+// awaits 获取所有通过句柄声明了异步启动的模块
+func (k *App) awaits() []moduleReady {
+	k.mutex.RLock()
+	hosts := k.hosts
+	k.mutex.RUnlock()
+
+	readies := make([]moduleReady, 0)
+	for _, host := range hosts {
+		host.mutex.Lock()
+		if host.ready != nil {
+			readies = append(readies, *host.ready)
+		}
+		host.mutex.Unlock()
+	}
+	return readies
+}
+
+func (host *moduleHost) Name() string {
+	return host.name
+}
+
+func (host *moduleHost) Config() Map {
+	host.app.mutex.RLock()
+	defer host.app.mutex.RUnlock()
+
+	if config, ok := host.app.global[host.name].(Map); ok {
+		return cloneMap(config)
+	}
+	return Map{}
+}
+
+func (host *moduleHost) Setting() Map {
+	return host.app.setting()
+}
+
+func (host *moduleHost) Register(regs ...Any) {
+	host.app.register(regs...)
+}
+
+func (host *moduleHost) Log(format string, args ...Any) {
+	host.app.logging(nil, LogInfo, fmt.Sprintf(format, args...), Map{"module": host.name})
+}
+
+func (host *moduleHost) Stop() {
+	host.app.stop()
+}
+
+func (host *moduleHost) Health(health HealthState) {
+	host.app.health.report(host.name, health)
+}
+
+func (host *moduleHost) Await() func() {
+	host.mutex.Lock()
+	defer host.mutex.Unlock()
+
+	if host.ready == nil {
+		host.ready = &moduleReady{host.name, make(chan struct{}), &sync.Once{}}
+	}
+	return host.ready.done
+}
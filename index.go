@@ -1,82 +1,96 @@
 package chef
 
 import (
+	"os"
+
 	. "github.com/chefsgo/base"
 )
 
 // Register 注册各种内容
 func Register(cfgs ...Any) {
-	core.register(cfgs...)
+	core.Register(cfgs...)
 }
 
 // Identify 声明当前节点的身份和版本
 // role 当前节点的角色
 // version 编译的版本，建议每次发布时更新版本
 func Identify(role string, versions ...string) {
-	core.identify(role, versions...)
+	core.Identify(role, versions...)
 }
 
 // Configure 开放修改默认配置
 // 比如，在代码中就可以设置一些默认配置
 // 这样就可以最大化的减少配置文件的依赖
+// 配置中的 ${ENV_NAME:-default} 和 ENC(...) 同样会被处理
 func Configure(cfg Map) {
-	core.configure(cfg)
+	core.Configure(cfg)
 }
 
+// Setting 获取setting，返回的是深度复制的一份，修改不会影响原配置
+// 可以用 Register("setting", Vars{...}) 定义setting，解析时会按定义校验
+// 按路径获取单个值，可以使用 SettingString("mail.host") 等方法
 func Setting() Map {
-	return core.setting()
+	return core.Setting()
+}
+
+// Reload 运行中重新加载配置
+// 不传参数时，重新从文件、环境变量、命令行参数中加载
+// 传入配置时，直接使用传入的配置，都会合并在 Configure 设置的默认配置之上
+// 有模块拒绝新配置时返回错误，setting保持不变
+func Reload(configs ...Map) error {
+	return core.Reload(configs...)
 }
 
 // Ready 准备好各模块
 // 当你需要写一个临时程序，但是又需要使用程序里的代码
 // 比如，导入老数据，整理文件或是数据，临时的采集程序等等
 // 就可以在临时代码中，调用chef.Ready()，然后做你需要做的事情
-func Ready() {
-	core.parse()
-	core.cluster()
-	core.initialize()
-	core.connect()
+// 有模块启动失败时，返回 *Failure，说明是哪个模块在哪个阶段失败
+// 有钩子中止启动时，返回 *HookFailure
+func Ready() error {
+	core.arguing(os.Args)
+	return core.Ready()
 }
 
-// Go 直接开跑
-func Go(args ...string) {
+// Go 直接开跑，使用命令行参数，支持子命令，如 app version, app check
+// 有模块启动失败时，记录日志并返回错误，已启动的模块会被终止
+// 子命令的退出码不为0时，返回 *ExitError，可以在 main 中按 Code 退出
+func Go(args ...string) error {
 	if l := len(args); l > 0 {
-		if l == 1 {
-			//role
-			core.identify(args[0])
-		} else {
-			//role, version
-			core.identify(args[0], args[1])
-		}
+		core.Identify(args[0], args[1:]...)
 	}
+	return core.Run(os.Args)
+}
+
+// Stop 停止运行，chef.Go 会结束等待，并终止所有模块
+// 可以在测试，或是嵌入其它程序中使用
+func Stop() {
+	core.Stop()
+}
 
-	core.parse()
-	core.cluster()
-	core.initialize()
-	core.connect()
-	core.launch()
-	core.waiting()
-	core.terminate()
+// Done 所有模块终止完成以后，此通道会被关闭
+func Done() <-chan struct{} {
+	return core.Done()
 }
 
 func Name() string {
-	return core.config.name
+	return core.Name()
 }
 func Role() string {
-	return core.config.role
+	return core.Role()
 }
 func Version() string {
-	return core.config.version
+	return core.Version()
 }
 func Mode() env {
-	return core.config.mode
+	return core.Mode()
 }
 func Developing() bool {
-	return core.config.mode == developing
+	return core.Developing()
 }
 func Testing() bool {
-	return core.config.mode == testing
+	return core.Testing()
 }
 func Production() bool {
-	return core.config.mode == production
+	return core.Production()
 }
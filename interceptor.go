@@ -0,0 +1,135 @@
+package chef
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/chefsgo/base"
+)
+
+var (
+	// RecoveryInterceptor 方法中的panic转为 Panicked 返回，并记录错误日志
+	// 用 Register("recovery", chef.RecoveryInterceptor) 启用
+	RecoveryInterceptor = Interceptor{
+		Name: "recovery", Text: "panic恢复", Order: -1000,
+		Action: recoveryIntercept,
+	}
+
+	// LoggingInterceptor 记录每次调用的方法、耗时和结果
+	// 用 Register("logging", chef.LoggingInterceptor) 启用
+	LoggingInterceptor = Interceptor{
+		Name: "logging", Text: "调用日志", Order: -900,
+		Action: loggingIntercept,
+	}
+)
+
+type (
+	// Interceptor 方法调用的拦截器
+	// 用 Register("name", Interceptor{...}) 注册，Logic 为空的是全局拦截器
+	// 也可以直接写在 Method.Interceptors 中，只对该方法生效
+	// 执行顺序为：全局、Logic、方法，同一级按 Order 从小到大，相同的按注册顺序
+	Interceptor struct {
+		Name string
+		Text string
+		// Logic 只拦截此 Logic 下的方法，如 user 匹配 user.get, user.list
+		Logic string
+		Order int
+		// Action 拦截的方法，调用 next 继续执行，不调用就中止
+		// 可以修改 next 返回的结果
+		Action func(ctx *Context, next func() (Map, Res)) (Map, Res)
+	}
+)
+
+// Interceptor 注册拦截器，同名的覆盖
+func (module *engineModule) Interceptor(name string, config Interceptor, override bool) {
+	if config.Name == "" {
+		config.Name = name
+	}
+	if config.Action == nil {
+		panic("Invalid interceptor: " + name)
+	}
+
+	module.mutex.Lock()
+	defer module.mutex.Unlock()
+
+	for i, vv := range module.interceptors {
+		if vv.Name == config.Name {
+			if override {
+				module.interceptors[i] = config
+			}
+			return
+		}
+	}
+	module.interceptors = append(module.interceptors, config)
+}
+
+// intercepting 获取方法要执行的拦截器，按执行顺序
+func (module *engineModule) intercepting(name string, config Method) []Interceptor {
+	module.mutex.Lock()
+	globals, logics := []Interceptor{}, []Interceptor{}
+	for _, vv := range module.interceptors {
+		if vv.Logic == "" {
+			globals = append(globals, vv)
+		} else if strings.HasPrefix(name, vv.Logic+".") {
+			logics = append(logics, vv)
+		}
+	}
+	module.mutex.Unlock()
+
+	methods := make([]Interceptor, len(config.Interceptors))
+	copy(methods, config.Interceptors)
+
+	interceptors := []Interceptor{}
+	for _, vvs := range [][]Interceptor{globals, logics, methods} {
+		sort.SliceStable(vvs, func(i, j int) bool {
+			return vvs[i].Order < vvs[j].Order
+		})
+		interceptors = append(interceptors, vvs...)
+	}
+	return interceptors
+}
+
+// intercept 把拦截器串成调用链，最里面是方法本身
+func intercept(ctx *Context, interceptors []Interceptor, action func() (Map, Res)) (Map, Res) {
+	if len(interceptors) == 0 {
+		return action()
+	}
+
+	interceptor := interceptors[0]
+	if interceptor.Action == nil {
+		return intercept(ctx, interceptors[1:], action)
+	}
+	return interceptor.Action(ctx, func() (Map, Res) {
+		return intercept(ctx, interceptors[1:], action)
+	})
+}
+
+func recoveryIntercept(ctx *Context, next func() (Map, Res)) (data Map, res Res) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			ctx.Error("invoke panic", Map{"method": ctx.Name, "panic": fmt.Sprintf("%v", rec), "stack": string(debug.Stack())})
+			data, res = nil, Panicked
+		}
+	}()
+	return next()
+}
+
+func loggingIntercept(ctx *Context, next func() (Map, Res)) (Map, Res) {
+	start := time.Now()
+	data, res := next()
+
+	fields := Map{"method": ctx.Name, "duration": time.Since(start).String()}
+	if res != nil {
+		fields["code"] = res.Code()
+		fields["state"] = res.State()
+	}
+	if res != nil && res.Fail() {
+		ctx.Warning("invoke failed", fields)
+	} else {
+		ctx.Info("invoke", fields)
+	}
+	return data, res
+}
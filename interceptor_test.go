@@ -0,0 +1,149 @@
+package chef_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/chefsgo/base"
+	"github.com/chefsgo/chef"
+)
+
+func TestInterceptorOrder(t *testing.T) {
+	app := chef.New()
+	app.Isolate()
+
+	order := []string{}
+	record := func(name string) func(ctx *chef.Context, next func() (Map, Res)) (Map, Res) {
+		return func(ctx *chef.Context, next func() (Map, Res)) (Map, Res) {
+			order = append(order, name)
+			return next()
+		}
+	}
+
+	// 注册的顺序和执行的顺序不一样，按级别和 Order 排列
+	app.Register("method", chef.Method{
+		Interceptors: []chef.Interceptor{
+			{Name: "method2", Order: 2, Action: record("method2")},
+			{Name: "method1", Order: 1, Action: record("method1")},
+		},
+		Action: func(ctx *chef.Context) {
+			order = append(order, "action")
+		},
+	})
+	app.Register("user.get", chef.Method{
+		Interceptors: []chef.Interceptor{
+			{Name: "method", Action: record("method")},
+		},
+		Action: func(ctx *chef.Context) {
+			order = append(order, "action")
+		},
+	})
+	app.Register("logic", chef.Interceptor{Logic: "user", Order: -10, Action: record("logic")})
+	app.Register("global2", chef.Interceptor{Order: 2, Action: record("global2")})
+	app.Register("global1", chef.Interceptor{Order: 1, Action: record("global1")})
+	app.Register("other", chef.Interceptor{Logic: "order", Action: record("other")})
+
+	if err := app.Ready(); err != nil {
+		t.Fatalf("ready: %v", err)
+	}
+	defer app.Terminate()
+
+	meta := app.Meta()
+	meta.Invoke("user.get")
+	if actual := strings.Join(order, ","); actual != "global1,global2,logic,method,action" {
+		t.Errorf("unexpected order %s", actual)
+	}
+
+	order = []string{}
+	meta.Invoke("method")
+	if actual := strings.Join(order, ","); actual != "global1,global2,method1,method2,action" {
+		t.Errorf("unexpected order %s", actual)
+	}
+}
+
+func TestInterceptorSeesDenied(t *testing.T) {
+	app := chef.New()
+	app.Isolate()
+
+	var seen Res
+	app.Register("seen", chef.Interceptor{Action: func(ctx *chef.Context, next func() (Map, Res)) (Map, Res) {
+		data, res := next()
+		seen = res
+		return data, res
+	}})
+	app.Register("secret", chef.Method{
+		Auth: true,
+		Action: func(ctx *chef.Context) {
+			t.Error("action should not run without auth")
+		},
+	})
+
+	if err := app.Ready(); err != nil {
+		t.Fatalf("ready: %v", err)
+	}
+	defer app.Terminate()
+
+	meta := app.Meta()
+	meta.Invoke("secret")
+	if res := meta.Result(); res == nil || res.State() != chef.Unsigned.State() {
+		t.Errorf("expected unsigned, got %v", res)
+	}
+	if seen == nil || seen.State() != chef.Unsigned.State() {
+		t.Errorf("interceptor should see unsigned, got %v", seen)
+	}
+}
+
+type captureLogDriver struct {
+	logs chan chef.Log
+}
+
+func (driver *captureLogDriver) Open(config Map) (chef.LogWriter, error) {
+	return driver, nil
+}
+func (driver *captureLogDriver) Write(log chef.Log) error {
+	driver.logs <- log
+	return nil
+}
+func (driver *captureLogDriver) Flush() error { return nil }
+func (driver *captureLogDriver) Close() error { return nil }
+
+func TestTimeoutLogged(t *testing.T) {
+	app := chef.New()
+	app.Isolate()
+
+	driver := &captureLogDriver{logs: make(chan chef.Log, 100)}
+	app.Register("capture", chef.LogDriver(driver))
+	app.Configure(Map{"log": Map{"buffer": 0, "outputs": Map{"capture": Map{}}}})
+
+	release := make(chan struct{})
+	defer close(release)
+	app.Register("slow", chef.Method{
+		Timeout: 20 * time.Millisecond,
+		Action: func(ctx *chef.Context) {
+			<-release
+		},
+	})
+
+	if err := app.Ready(); err != nil {
+		t.Fatalf("ready: %v", err)
+	}
+	defer app.Terminate()
+
+	meta := app.Meta()
+	meta.Invoke("slow")
+	if res := meta.Result(); res == nil || res.State() != chef.Timeout.State() {
+		t.Fatalf("expected timeout, got %v", res)
+	}
+
+	for {
+		select {
+		case log := <-driver.logs:
+			if log.Fields["method"] == "slow" && log.Fields["state"] == chef.Timeout.State() {
+				return
+			}
+		default:
+			t.Fatal("timeout not logged")
+		}
+	}
+}
@@ -0,0 +1,657 @@
+package chef
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/chefsgo/base"
+)
+
+func newLoggerModule(app *App) *loggerModule {
+	module := &loggerModule{
+		app: app,
+		config: loggerConfig{
+			Level: LogInfo, Buffer: 1024,
+		},
+		drivers: make(map[string]LogDriver, 0),
+	}
+
+	module.Driver("console", &consoleLogDriver{}, true)
+	module.Driver("json", &consoleLogDriver{format: "json"}, true)
+	module.Driver("file", &fileLogDriver{}, true)
+
+	module.outputs = module.defaults()
+
+	return module
+}
+
+// 日志级别
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarning
+	LogError
+)
+
+type (
+	// LogLevel 日志级别
+	LogLevel int
+
+	// Log 一条日志
+	Log struct {
+		Time  time.Time `json:"time"`
+		Level LogLevel  `json:"level"`
+		// Node 节点，name-role-version
+		Node string `json:"node"`
+		Body string `json:"body"`
+		// Fields 结构化的字段
+		Fields Map `json:"fields,omitempty"`
+		// Trace, Language 从 Meta 中带过来，用来关联同一次调用
+		Trace    string `json:"trace,omitempty"`
+		Language string `json:"language,omitempty"`
+	}
+
+	// LogDriver 日志输出驱动
+	// 用 Register("name", LogDriver) 注册，配置中 [log.outputs.xxx] 的 driver 指定使用哪个
+	LogDriver interface {
+		Open(config Map) (LogWriter, error)
+	}
+	// LogWriter 日志输出
+	LogWriter interface {
+		Write(log Log) error
+		Flush() error
+		Close() error
+	}
+
+	loggerConfig struct {
+		// Level 最低输出的级别
+		Level LogLevel
+		// Buffer 异步缓冲的条数，为0表示同步写入
+		Buffer int
+		// Outputs 输出，为空时输出到控制台
+		Outputs map[string]Map
+	}
+
+	loggerOutput struct {
+		name   string
+		level  LogLevel
+		writer LogWriter
+	}
+
+	loggerModule struct {
+		app    *App
+		mutex  sync.RWMutex
+		config loggerConfig
+
+		drivers map[string]LogDriver
+		outputs []loggerOutput
+
+		// queue 异步写入的队列，为nil时同步写入
+		queue chan Log
+		done  chan struct{}
+	}
+)
+
+// String 级别名称
+func (level LogLevel) String() string {
+	switch level {
+	case LogDebug:
+		return "DEBUG"
+	case LogInfo:
+		return "INFO"
+	case LogWarning:
+		return "WARNING"
+	case LogError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL%d", int(level))
+}
+
+// MarshalText JSON中输出为级别名称
+func (level LogLevel) MarshalText() ([]byte, error) {
+	return []byte(level.String()), nil
+}
+
+// parseLogLevel 解析级别，不区分大小写
+func parseLogLevel(s string) (LogLevel, bool) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LogDebug, true
+	case "info":
+		return LogInfo, true
+	case "warn", "warning":
+		return LogWarning, true
+	case "error":
+		return LogError, true
+	}
+	return LogInfo, false
+}
+
+// Name 模块名称
+func (module *loggerModule) Name() string {
+	return "log"
+}
+
+// Register
+func (module *loggerModule) Register(name string, value Any, override bool) {
+	switch val := value.(type) {
+	case LogDriver:
+		module.Driver(name, val, override)
+	}
+}
+
+// Configure
+func (module *loggerModule) Configure(global Map) {
+	var config Map
+	if vv, ok := global["log"].(Map); ok {
+		config = vv
+	}
+
+	module.mutex.Lock()
+	defer module.mutex.Unlock()
+
+	if level, ok := config["level"].(string); ok {
+		if vv, ok := parseLogLevel(level); ok {
+			module.config.Level = vv
+		}
+	}
+	if buffer, ok := parseIntFromMap(config, "buffer"); ok && buffer >= 0 {
+		module.config.Buffer = int(buffer)
+	}
+	if outputs, ok := config["outputs"].(Map); ok {
+		module.config.Outputs = make(map[string]Map, 0)
+		for name, vv := range outputs {
+			if output, ok := vv.(Map); ok {
+				module.config.Outputs[name] = output
+			}
+		}
+	}
+}
+
+// Initialize 打开配置的输出，开始异步写入
+// 日志模块最先初始化，其它模块初始化时的日志就已经按配置输出
+func (module *loggerModule) Initialize() {
+	module.mutex.Lock()
+	defer module.mutex.Unlock()
+
+	if module.queue != nil {
+		return
+	}
+
+	if len(module.config.Outputs) > 0 {
+		names := []string{}
+		for name := range module.config.Outputs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		outputs := make([]loggerOutput, 0)
+		for _, name := range names {
+			config := module.config.Outputs[name]
+
+			driverName, _ := config["driver"].(string)
+			if driverName == "" {
+				driverName = name
+			}
+			driver, ok := module.drivers[driverName]
+			if ok == false {
+				fmt.Fprintf(os.Stderr, "%s log %s unknown driver %s\n", CHEFSGO, name, driverName)
+				continue
+			}
+			writer, err := driver.Open(config)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s log %s %v\n", CHEFSGO, name, err)
+				continue
+			}
+
+			level := module.config.Level
+			if vv, ok := config["level"].(string); ok {
+				if lv, ok := parseLogLevel(vv); ok {
+					level = lv
+				}
+			}
+			outputs = append(outputs, loggerOutput{name, level, writer})
+		}
+
+		// 都打不开的时候，仍然输出到控制台
+		if len(outputs) > 0 {
+			module.closing(module.outputs)
+			module.outputs = outputs
+		}
+	}
+
+	if module.config.Buffer > 0 {
+		module.queue = make(chan Log, module.config.Buffer)
+		module.done = make(chan struct{})
+		go module.writing(module.queue, module.done, module.outputs)
+	}
+}
+
+// Connect
+func (module *loggerModule) Connect() {
+}
+
+// Launch
+func (module *loggerModule) Launch() {
+}
+
+// Terminate 什么也不做
+// chef 在终止的最后调用 close，保证退出过程中的日志都已经写入
+func (module *loggerModule) Terminate() {
+}
+
+// Driver 注册日志驱动
+func (module *loggerModule) Driver(name string, driver LogDriver, override bool) {
+	module.mutex.Lock()
+	defer module.mutex.Unlock()
+
+	if driver == nil {
+		panic("Invalid log driver: " + name)
+	}
+
+	if override {
+		module.drivers[name] = driver
+	} else {
+		if _, ok := module.drivers[name]; ok == false {
+			module.drivers[name] = driver
+		}
+	}
+}
+
+// defaults 默认的控制台输出
+func (module *loggerModule) defaults() []loggerOutput {
+	return []loggerOutput{
+		{"console", LogDebug, &consoleLogWriter{format: "text", out: os.Stderr}},
+	}
+}
+
+// Logging 写入一条日志
+// 异步写入时，队列满了会等待，不会丢弃
+func (module *loggerModule) Logging(log Log) {
+	module.mutex.RLock()
+	defer module.mutex.RUnlock()
+
+	if log.Level < module.config.Level {
+		return
+	}
+	if log.Time.IsZero() {
+		log.Time = time.Now()
+	}
+	if log.Node == "" {
+		log.Node = module.app.identity()
+	}
+
+	if module.queue != nil {
+		module.queue <- log
+		return
+	}
+	module.write(module.outputs, log)
+}
+
+// write 写入到所有的输出
+func (module *loggerModule) write(outputs []loggerOutput, log Log) {
+	for _, output := range outputs {
+		if log.Level < output.level {
+			continue
+		}
+		if err := output.writer.Write(log); err != nil {
+			fmt.Fprintf(os.Stderr, "%s log %s %v\n", CHEFSGO, output.name, err)
+		}
+	}
+}
+
+// writing 异步写入，直到队列关闭
+func (module *loggerModule) writing(queue chan Log, done chan struct{}, outputs []loggerOutput) {
+	defer close(done)
+
+	for log := range queue {
+		module.write(outputs, log)
+
+		// 队列空了就刷一下，不让日志停留在缓冲中
+		if len(queue) == 0 {
+			for _, output := range outputs {
+				output.writer.Flush()
+			}
+		}
+	}
+}
+
+// closing 刷新并关闭输出
+func (module *loggerModule) closing(outputs []loggerOutput) {
+	for _, output := range outputs {
+		if err := output.writer.Flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s log %s %v\n", CHEFSGO, output.name, err)
+		}
+		if err := output.writer.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s log %s %v\n", CHEFSGO, output.name, err)
+		}
+	}
+}
+
+// close 等待队列中的日志都写入，然后关闭所有输出
+// 之后的日志同步输出到控制台
+func (module *loggerModule) close() {
+	module.mutex.Lock()
+	defer module.mutex.Unlock()
+
+	if module.queue != nil {
+		close(module.queue)
+		<-module.done
+		module.queue = nil
+		module.done = nil
+	}
+
+	module.closing(module.outputs)
+	module.outputs = module.defaults()
+}
+
+// identity 节点名称，用于日志
+func (k *App) identity() string {
+	if k.config.name == k.config.role || k.config.role == "" {
+		return fmt.Sprintf("%s-%s", k.config.name, k.config.version)
+	}
+	return fmt.Sprintf("%s-%s-%s", k.config.name, k.config.role, k.config.version)
+}
+
+// logging 写日志，fields 多个时合并
+func (k *App) logging(meta *Meta, level LogLevel, body string, fields ...Map) {
+	log := Log{Level: level, Body: body}
+	if len(fields) > 0 {
+		log.Fields = Map{}
+		for _, field := range fields {
+			for key, val := range field {
+				log.Fields[key] = val
+			}
+		}
+	}
+	if meta != nil {
+		log.Trace = meta.Trace()
+		log.Language = meta.language
+	}
+	k.logger.Logging(log)
+}
+
+// Debug 写调试日志，fields 为结构化的字段
+func (k *App) Debug(body string, fields ...Map) {
+	k.logging(nil, LogDebug, body, fields...)
+}
+
+// Info 写信息日志
+func (k *App) Info(body string, fields ...Map) {
+	k.logging(nil, LogInfo, body, fields...)
+}
+
+// Warning 写警告日志
+func (k *App) Warning(body string, fields ...Map) {
+	k.logging(nil, LogWarning, body, fields...)
+}
+
+// Error 写错误日志
+func (k *App) Error(body string, fields ...Map) {
+	k.logging(nil, LogError, body, fields...)
+}
+
+// Debug 写调试日志，带上 Meta 的 Trace 和 Language
+func (meta *Meta) Debug(body string, fields ...Map) {
+	meta.owner().logging(meta, LogDebug, body, fields...)
+}
+
+// Info 写信息日志，带上 Meta 的 Trace 和 Language
+func (meta *Meta) Info(body string, fields ...Map) {
+	meta.owner().logging(meta, LogInfo, body, fields...)
+}
+
+// Warning 写警告日志，带上 Meta 的 Trace 和 Language
+func (meta *Meta) Warning(body string, fields ...Map) {
+	meta.owner().logging(meta, LogWarning, body, fields...)
+}
+
+// Error 写错误日志，带上 Meta 的 Trace 和 Language
+func (meta *Meta) Error(body string, fields ...Map) {
+	meta.owner().logging(meta, LogError, body, fields...)
+}
+
+// Debug 写调试日志
+func Debug(body string, fields ...Map) {
+	core.Debug(body, fields...)
+}
+
+// Info 写信息日志
+func Info(body string, fields ...Map) {
+	core.Info(body, fields...)
+}
+
+// Warning 写警告日志
+func Warning(body string, fields ...Map) {
+	core.Warning(body, fields...)
+}
+
+// Error 写错误日志
+func Error(body string, fields ...Map) {
+	core.Error(body, fields...)
+}
+
+// logText 日志的文本格式
+func logText(log Log) string {
+	line := fmt.Sprintf("%s [%s] %s", log.Time.Format("2006/01/02 15:04:05.000"), log.Level, log.Body)
+
+	if len(log.Fields) > 0 {
+		keys := []string{}
+		for key := range log.Fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			line += fmt.Sprintf(" %s=%v", key, log.Fields[key])
+		}
+	}
+	if log.Trace != "" {
+		line += " trace=" + log.Trace
+	}
+	if log.Language != "" {
+		line += " language=" + log.Language
+	}
+	return line
+}
+
+// logLine 按格式生成一行日志
+func logLine(log Log, format string) ([]byte, error) {
+	if format == "json" {
+		bytes, err := json.Marshal(log)
+		if err != nil {
+			return nil, err
+		}
+		return append(bytes, '\n'), nil
+	}
+	return []byte(logText(log) + "\n"), nil
+}
+
+//------------------------- console ----------------------------
+
+type (
+	// consoleLogDriver 控制台输出
+	// format 为 text 或 json，output 为 stderr 或 stdout
+	consoleLogDriver struct {
+		format string
+	}
+	consoleLogWriter struct {
+		mutex  sync.Mutex
+		format string
+		out    io.Writer
+	}
+)
+
+func (driver *consoleLogDriver) Open(config Map) (LogWriter, error) {
+	writer := &consoleLogWriter{format: driver.format, out: os.Stderr}
+	if format, ok := config["format"].(string); ok {
+		writer.format = format
+	}
+	if output, ok := config["output"].(string); ok && output == "stdout" {
+		writer.out = os.Stdout
+	}
+	return writer, nil
+}
+
+func (writer *consoleLogWriter) Write(log Log) error {
+	line, err := logLine(log, writer.format)
+	if err != nil {
+		return err
+	}
+
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	_, err = writer.out.Write(line)
+	return err
+}
+
+func (writer *consoleLogWriter) Flush() error {
+	return nil
+}
+
+func (writer *consoleLogWriter) Close() error {
+	return nil
+}
+
+//------------------------- file ----------------------------
+
+type (
+	// fileLogDriver 文件输出，按大小滚动
+	// file 文件路径，maxsize 单个文件的大小，单位MB，默认100
+	// maxfiles 保留的旧文件数，默认10，format 为 text 或 json
+	fileLogDriver struct{}
+	fileLogWriter struct {
+		mutex    sync.Mutex
+		format   string
+		path     string
+		maxsize  int64
+		maxfiles int
+
+		file   *os.File
+		buffer *bufio.Writer
+		size   int64
+	}
+)
+
+func (driver *fileLogDriver) Open(config Map) (LogWriter, error) {
+	writer := &fileLogWriter{
+		format: "text", path: "logs/chef.log",
+		maxsize: 100 << 20, maxfiles: 10,
+	}
+	if format, ok := config["format"].(string); ok {
+		writer.format = format
+	}
+	if path, ok := config["file"].(string); ok && path != "" {
+		writer.path = path
+	}
+	if maxsize, ok := parseIntFromMap(config, "maxsize"); ok && maxsize > 0 {
+		writer.maxsize = maxsize << 20
+	}
+	if maxfiles, ok := parseIntFromMap(config, "maxfiles"); ok && maxfiles >= 0 {
+		writer.maxfiles = int(maxfiles)
+	}
+
+	if err := writer.open(); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// open 打开文件，追加写入
+func (writer *fileLogWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(writer.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(writer.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	writer.file = file
+	writer.buffer = bufio.NewWriter(file)
+	writer.size = info.Size()
+	return nil
+}
+
+// rotate 滚动文件，chef.log 改名为 chef.log.1，原来的 .1 改为 .2，依次类推
+func (writer *fileLogWriter) rotate() error {
+	if err := writer.buffer.Flush(); err != nil {
+		return err
+	}
+	if err := writer.file.Close(); err != nil {
+		return err
+	}
+
+	if writer.maxfiles > 0 {
+		for i := writer.maxfiles - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", writer.path, i), fmt.Sprintf("%s.%d", writer.path, i+1))
+		}
+		if err := os.Rename(writer.path, writer.path+".1"); err != nil {
+			return err
+		}
+	} else {
+		if err := os.Remove(writer.path); err != nil {
+			return err
+		}
+	}
+
+	return writer.open()
+}
+
+func (writer *fileLogWriter) Write(log Log) error {
+	line, err := logLine(log, writer.format)
+	if err != nil {
+		return err
+	}
+
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.file == nil {
+		return os.ErrClosed
+	}
+	if writer.size > 0 && writer.size+int64(len(line)) > writer.maxsize {
+		if err := writer.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := writer.buffer.Write(line)
+	writer.size += int64(n)
+	return err
+}
+
+func (writer *fileLogWriter) Flush() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.file == nil {
+		return nil
+	}
+	return writer.buffer.Flush()
+}
+
+func (writer *fileLogWriter) Close() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.file == nil {
+		return nil
+	}
+	writer.buffer.Flush()
+	err := writer.file.Close()
+	writer.file = nil
+	return err
+}
@@ -0,0 +1,97 @@
+package chef_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/chefsgo/base"
+	"github.com/chefsgo/chef/cheftest"
+)
+
+func TestJSONLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chef.log")
+	app := cheftest.New(t, Map{
+		"name": "demo", "role": "api", "version": "v1.0.0",
+		"log": Map{"buffer": 0, "outputs": Map{
+			"file": Map{"file": path, "format": "json"},
+		}},
+	})
+
+	app.Meta(cheftest.Caller{Trace: "t1", Language: "zh"}).Warning("user login", Map{"user": "chef", "count": 3})
+	// 终止时刷新并关闭输出
+	app.Terminate()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		log := Map{}
+		if err := json.Unmarshal(scanner.Bytes(), &log); err != nil {
+			t.Fatalf("invalid json line %s: %v", scanner.Text(), err)
+		}
+		if log["body"] != "user login" {
+			continue
+		}
+
+		fields, _ := log["fields"].(map[string]Any)
+		if log["level"] != "WARNING" || log["node"] != "demo-api-v1.0.0" || log["time"] == nil {
+			t.Errorf("unexpected log %v", log)
+		}
+		if log["trace"] != "t1" || log["language"] != "zh" {
+			t.Errorf("expected trace and language from meta, got %v", log)
+		}
+		if fields["user"] != "chef" || fields["count"] != float64(3) {
+			t.Errorf("unexpected fields %v", fields)
+		}
+		return
+	}
+	t.Error("log not found")
+}
+
+func TestFileLogRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chef.log")
+	app := cheftest.New(t, Map{
+		"log": Map{"buffer": 0, "outputs": Map{
+			"file": Map{"file": path, "maxsize": 1, "maxfiles": 2},
+		}},
+	})
+
+	// 每条大约1KB，写满3MB多，滚动3次，只保留2个旧文件
+	body := strings.Repeat("x", 1000)
+	for i := 0; i < 3200; i++ {
+		app.Info(body, Map{"index": i})
+	}
+	app.Terminate()
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Errorf("expected %s: %v", filepath.Base(name), err)
+			continue
+		}
+		if info.Size() > 1<<20 {
+			t.Errorf("%s exceeds maxsize: %d", filepath.Base(name), info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); os.IsNotExist(err) == false {
+		t.Errorf("expected only 2 rotated files, %s.3: %v", filepath.Base(path), err)
+	}
+
+	// 最新的在当前文件，最旧的在 .2
+	last, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(last), fmt.Sprintf("index=%d", 3199)) == false {
+		t.Error("expected the last log in the current file")
+	}
+}
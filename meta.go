@@ -1,6 +1,7 @@
 package chef
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"sync"
@@ -11,6 +12,9 @@ import (
 
 type (
 	Meta struct {
+		// app 所属的程序，为nil时使用默认程序
+		app *App
+
 		name    string
 		payload Map
 
@@ -25,6 +29,11 @@ type (
 		tempfiles []string
 
 		verify *Token
+
+		// timeout 调用时指定的超时时间
+		timeout time.Duration
+		// ctx 正在进行的调用的 context，嵌套调用时继承期限
+		ctx context.Context
 	}
 	Metadata struct {
 		Name     string `json:"n,omitempty"`
@@ -37,6 +46,14 @@ type (
 	}
 )
 
+// owner 获取所属的程序
+func (meta *Meta) owner() *App {
+	if meta.app != nil {
+		return meta.app
+	}
+	return core
+}
+
 //最终的清理工作
 func (meta *Meta) close() {
 	for _, file := range meta.tempfiles {
@@ -128,7 +145,7 @@ func (meta *Meta) Result(res ...Res) Res {
 
 //获取langString
 func (meta *Meta) String(key string, args ...Any) string {
-	return mBasic.String(meta.Language(), key, args...)
+	return meta.owner().basic.String(meta.Language(), key, args...)
 }
 
 //----------------------- 签名系统 end ---------------------------------
@@ -141,7 +158,7 @@ func (meta *Meta) Invoke(name string, values ...Any) Map {
 			value = vv
 		}
 	}
-	vvv, res := mEngine.Invoke(meta, name, value)
+	vvv, res := meta.owner().engine.Invoke(meta, name, value)
 	meta.result = res
 
 	return vvv
@@ -154,7 +171,7 @@ func (meta *Meta) Invokes(name string, values ...Any) []Map {
 			value = vv
 		}
 	}
-	vvs, res := mEngine.Invokes(meta, name, value)
+	vvs, res := meta.owner().engine.Invokes(meta, name, value)
 	meta.result = res
 	return vvs
 }
@@ -165,7 +182,7 @@ func (meta *Meta) Invoked(name string, values ...Any) bool {
 			value = vv
 		}
 	}
-	vvv, res := mEngine.Invoked(meta, name, value)
+	vvv, res := meta.owner().engine.Invoked(meta, name, value)
 	meta.result = res
 	return vvv
 }
@@ -176,7 +193,7 @@ func (meta *Meta) Invoking(name string, offset, limit int64, values ...Any) (int
 			value = vv
 		}
 	}
-	count, items, res := mEngine.Invoking(meta, name, offset, limit, value)
+	count, items, res := meta.owner().engine.Invoking(meta, name, offset, limit, value)
 	meta.result = res
 	return count, items
 }
@@ -190,7 +207,7 @@ func (meta *Meta) Invoker(name string, values ...Any) (Map, []Map) {
 			value = vv
 		}
 	}
-	item, items, res := mEngine.Invoker(meta, name, value)
+	item, items, res := meta.owner().engine.Invoker(meta, name, value)
 	meta.result = res
 	return item, items
 }
@@ -202,13 +219,13 @@ func (meta *Meta) Invokee(name string, values ...Any) float64 {
 			value = vv
 		}
 	}
-	count, res := mEngine.Invokee(meta, name, value)
+	count, res := meta.owner().engine.Invokee(meta, name, value)
 	meta.result = res
 	return count
 }
 
 func (meta *Meta) Logic(name string, settings ...Map) *Logic {
-	return mEngine.Logic(meta, name, settings...)
+	return meta.owner().engine.Logic(meta, name, settings...)
 }
 
 //------- 服务调用 end-----------------
@@ -284,7 +301,7 @@ func (meta *Meta) Sign(auth bool, payload Map, ends ...time.Duration) string {
 	if tid := meta.Id(); tid != "" {
 		verify.Header.Id = tid
 	} else {
-		verify.Header.Id = mCodec.Generate()
+		verify.Header.Id = meta.owner().codec.Generate()
 	}
 
 	verify.Header.Auth = auth
@@ -294,7 +311,7 @@ func (meta *Meta) Sign(auth bool, payload Map, ends ...time.Duration) string {
 		verify.Header.End = now.Add(ends[0]).Unix()
 	}
 
-	token, err := mToken.Sign(verify)
+	token, err := meta.owner().token.Sign(verify)
 	if err != nil {
 		meta.Result(errorResult(err))
 		return ""
@@ -309,7 +326,7 @@ func (meta *Meta) Sign(auth bool, payload Map, ends ...time.Duration) string {
 
 // Verify 验证签名
 func (meta *Meta) Verify(token string) error {
-	verify, err := mToken.Verify(token)
+	verify, err := meta.owner().token.Verify(token)
 	if verify != nil {
 		meta.token = token
 		meta.verify = verify
@@ -1,6 +1,9 @@
 package chef
 
 import (
+	"fmt"
+	"sync"
+
 	. "github.com/chefsgo/base"
 )
 
@@ -13,10 +16,149 @@ type (
 		Launch()
 		Terminate()
 	}
+
+	// Named 模块名称，可选实现
+	// 实现以后，其它模块才可以依赖它
+	Named interface {
+		Name() string
+	}
+
+	// Depender 模块依赖，可选实现
+	// 返回依赖的模块名称，被依赖的模块会先初始化，后终止
+	Depender interface {
+		Depends() []string
+	}
+
+	// Failable 可返回错误的生命周期，可选实现
+	// 实现以后，chef会调用这些方法，来代替Module中对应的方法
+	// 返回错误时启动中止，已经初始化的模块会按相反的顺序终止
+	Failable interface {
+		TryInitialize() error
+		TryConnect() error
+		TryLaunch() error
+	}
+
+	// Reloader 运行中重新加载配置，可选实现
+	// 分两步，所有模块的 Verify 都通过以后，才会调用 Reload
+	// Verify 返回错误表示拒绝新的配置，不能修改模块的状态
+	// 没有实现的模块忽略重载
+	Reloader interface {
+		Verify(Map) error
+		Reload(Map)
+	}
+
+	// Awaiter 异步启动的模块，可选实现
+	// Launch 之前，chef 会调用 Await 传入 ready，模块准备好以后调用 ready()
+	// 所有模块都准备好以后，才会触发 StartTrigger
+	Awaiter interface {
+		Await(ready func())
+	}
+
+	// Attacher 需要访问chef的模块，可选实现
+	// 注册模块时，chef 会调用 Attach 传入属于它自己的句柄
+	Attacher interface {
+		Attach(host Host)
+	}
+
+	// Failure 模块生命周期失败的信息
+	// 返回错误和发生panic都会包装成Failure
+	Failure struct {
+		// Module 失败的模块名称
+		Module string
+		// Phase 失败的阶段，initialize, connect, launch, reload
+		Phase string
+		// Err 具体的错误
+		Err error
+		// Panic 是否是panic引起的
+		Panic bool
+	}
+
+	// moduleReady 模块的就绪状态
+	moduleReady struct {
+		name  string
+		ready chan struct{}
+		once  *sync.Once
+	}
+)
+
+const (
+	phaseInitialize = "initialize"
+	phaseConnect    = "connect"
+	phaseLaunch     = "launch"
+	phaseReload     = "reload"
 )
 
-func init() {
-	Register(mBasic)
-	Register(mCodec)
-	Register(mEngine)
+// Error 符合error接口
+func (failure *Failure) Error() string {
+	if failure.Panic {
+		return fmt.Sprintf("module %s %s panic: %v", failure.Module, failure.Phase, failure.Err)
+	}
+	return fmt.Sprintf("module %s %s failed: %v", failure.Module, failure.Phase, failure.Err)
+}
+
+// Unwrap 返回具体的错误
+func (failure *Failure) Unwrap() error {
+	return failure.Err
+}
+
+// done 通知模块已经准备好，多次调用只生效一次
+func (ready moduleReady) done() {
+	ready.once.Do(func() {
+		close(ready.ready)
+	})
+}
+
+// moduleName 获取模块名称，没有实现Named的使用类型名
+func moduleName(mod Module) string {
+	if named, ok := mod.(Named); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", mod)
+}
+
+// moduleDepends 获取模块的依赖
+func moduleDepends(mod Module) []string {
+	if depender, ok := mod.(Depender); ok {
+		return depender.Depends()
+	}
+	return nil
+}
+
+// modulePerform 执行模块某个阶段的方法
+// 实现了Failable的调用对应的Try方法，panic也转成Failure返回
+func modulePerform(mod Module, phase string) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = &Failure{moduleName(mod), phase, fmt.Errorf("%v", rec), true}
+		}
+	}()
+
+	failable, ok := mod.(Failable)
+	switch phase {
+	case phaseInitialize:
+		if ok {
+			err = failable.TryInitialize()
+		} else {
+			mod.Initialize()
+		}
+	case phaseConnect:
+		if ok {
+			err = failable.TryConnect()
+		} else {
+			mod.Connect()
+		}
+	case phaseLaunch:
+		if ok {
+			err = failable.TryLaunch()
+		} else {
+			mod.Launch()
+		}
+	}
+
+	if err != nil {
+		if _, ok := err.(*Failure); ok == false {
+			err = &Failure{moduleName(mod), phase, err, false}
+		}
+	}
+	return err
 }
@@ -0,0 +1,59 @@
+package chef_test
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/chefsgo/base"
+	"github.com/chefsgo/chef"
+	"github.com/chefsgo/chef/cheftest"
+)
+
+// rejectModule 配置中 reject 为 true 时拒绝重载
+type rejectModule struct {
+	reloads int
+}
+
+func (module *rejectModule) Register(name string, value Any, override bool) {}
+func (module *rejectModule) Configure(config Map)                           {}
+func (module *rejectModule) Initialize()                                    {}
+func (module *rejectModule) Connect()                                       {}
+func (module *rejectModule) Launch()                                        {}
+func (module *rejectModule) Terminate()                                     {}
+
+func (module *rejectModule) Verify(config Map) error {
+	if reject, _ := config["reject"].(bool); reject {
+		return errors.New("rejected")
+	}
+	return nil
+}
+func (module *rejectModule) Reload(config Map) {
+	module.reloads++
+}
+
+func TestReloadRejected(t *testing.T) {
+	module := &rejectModule{}
+	app := cheftest.New(t, module)
+
+	strings := func(hello string) Map {
+		return Map{chef.DEFAULT: Map{"strings": Map{"hello": hello}}}
+	}
+
+	if err := app.Reload(Map{"lang": strings("first")}); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if actual := app.String(chef.DEFAULT, "hello"); actual != "first" {
+		t.Errorf("expected first, got %s", actual)
+	}
+
+	// 后面的模块拒绝时，前面的模块也不能用新配置
+	if err := app.Reload(Map{"lang": strings("second"), "reject": true}); err == nil {
+		t.Fatal("expected reload rejected")
+	}
+	if actual := app.String(chef.DEFAULT, "hello"); actual != "first" {
+		t.Errorf("strings changed by rejected reload: %s", actual)
+	}
+	if module.reloads != 1 {
+		t.Errorf("expected 1 reload, got %d", module.reloads)
+	}
+}
@@ -3,6 +3,7 @@ package chef
 import (
 	"fmt"
 	"strings"
+	"sync"
 
 	. "github.com/chefsgo/base"
 )
@@ -17,6 +18,15 @@ var (
 	Unauthed = Result(6, "unauthed", "无权访问")
 	varEmpty = Result(7, "varempty", "%s不可为空")
 	varError = Result(8, "varerrpr", "%s无效")
+	Panicked = Result(9, "panicked", "执行出错")
+	Timeout  = Result(10, "timeout", "执行超时")
+	Canceled = Result(11, "canceled", "执行已取消")
+)
+
+var (
+	// resultDefines 所有用 Result 定义过的状态
+	resultMutex   sync.Mutex
+	resultDefines = make([]resultDefine, 0)
 )
 
 type (
@@ -29,6 +39,14 @@ type (
 		//携带的参数
 		args []Any
 	}
+
+	// resultDefine 状态定义，新建程序时注册到basic
+	resultDefine struct {
+		code     int
+		state    string
+		text     string
+		override bool
+	}
 )
 
 // OK 表示Res是否成功
@@ -98,6 +116,16 @@ func errorResult(err error) Res {
 	return &result{-1, err.Error(), []Any{}}
 }
 
+// definedResults 获取所有用 Result 定义过的状态
+func definedResults() []resultDefine {
+	resultMutex.Lock()
+	defer resultMutex.Unlock()
+
+	defines := make([]resultDefine, len(resultDefines))
+	copy(defines, resultDefines)
+	return defines
+}
+
 // Result 定义一个result，并自动注册state
 // state 表示状态key
 // text 表示状态对应的默认文案
@@ -108,8 +136,12 @@ func Result(code int, state string, text string, overrides ...bool) Res {
 	}
 
 	//自动注册状态和字串
-	mBasic.State(state, State(code), override)
-	mBasic.Strings(DEFAULT, Strings{state: text}, override)
+	//记录下来，之后 chef.New() 的程序也会带上
+	define := resultDefine{code, state, text, override}
+	resultMutex.Lock()
+	resultDefines = append(resultDefines, define)
+	resultMutex.Unlock()
+	mBasic.results([]resultDefine{define})
 
 	// result只携带state，而不携带string
 	// 具体的string需要配置context拿到lang之后生成
@@ -0,0 +1,199 @@
+package chef
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	. "github.com/chefsgo/base"
+)
+
+const (
+	// AEAD 配置加密使用的编解码器，AES-256-GCM
+	AEAD = "aead"
+
+	// secretEnv 配置加密的密钥，从环境变量读取
+	// 不会被当作 CHEF_ 开头的配置解析
+	secretEnv = "CHEF_SECRET_KEY"
+)
+
+var (
+	errSecretKey  = errors.New("Secret key missing, set " + secretEnv + ".")
+	errSecretData = errors.New("Invalid secret data.")
+
+	// ${ENV_NAME} 或 ${ENV_NAME:-default}
+	interpolateRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+	// ENC(...) 加密过的值
+	encryptedRegexp = regexp.MustCompile(`^ENC\((.*)\)$`)
+
+	aeadCodec = Codec{
+		Name: "AEAD加密", Text: "AES-256-GCM加密，密钥从环境变量 " + secretEnv + " 读取",
+		Encode: aeadEncode, Decode: aeadDecode,
+	}
+)
+
+// aeadCipher 用环境变量中的密钥生成AEAD
+// 密钥做一次sha256，所以可以是任意长度
+func aeadCipher() (cipher.AEAD, error) {
+	secret := os.Getenv(secretEnv)
+	if secret == "" {
+		return nil, errSecretKey
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// aeadEncode 加密，返回 base64(nonce+密文)
+func aeadEncode(v Any) (Any, error) {
+	var plain []byte
+	switch vv := v.(type) {
+	case string:
+		plain = []byte(vv)
+	case []byte:
+		plain = vv
+	default:
+		return nil, errInvalidCodecData
+	}
+
+	aead, err := aeadCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := aead.Seal(nonce, nonce, plain, nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// aeadDecode 解密，返回明文字串
+func aeadDecode(d Any, v Any) (Any, error) {
+	var text string
+	switch vv := d.(type) {
+	case string:
+		text = vv
+	case []byte:
+		text = string(vv)
+	default:
+		return nil, errInvalidCodecData
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(text)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := aeadCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errSecretData
+	}
+
+	nonce, data := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, data, nil)
+	if err != nil {
+		return nil, errSecretData
+	}
+	return string(plain), nil
+}
+
+// interpolate 替换字串中的环境变量
+// ${ENV_NAME} 不存在时替换为空，${ENV_NAME:-default} 不存在或为空时使用默认值
+func interpolate(s string) string {
+	if strings.Contains(s, "${") == false {
+		return s
+	}
+	return interpolateRegexp.ReplaceAllStringFunc(s, func(match string) string {
+		parts := interpolateRegexp.FindStringSubmatch(match)
+		if val := os.Getenv(parts[1]); val != "" {
+			return val
+		}
+		return parts[3]
+	})
+}
+
+// resolveValue 处理单个值，先替换环境变量，再用程序自己的编解码器解密
+func (k *App) resolveValue(value Any) (Any, error) {
+	switch vv := value.(type) {
+	case string:
+		text := interpolate(vv)
+		if matchs := encryptedRegexp.FindStringSubmatch(text); matchs != nil {
+			plain, err := k.codec.Decrypt(AEAD, matchs[1])
+			if err != nil {
+				return nil, err
+			}
+			return plain, nil
+		}
+		return text, nil
+	case Map:
+		return vv, k.resolveConfig(vv)
+	case []Any:
+		for i, v := range vv {
+			val, err := k.resolveValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %v", i, err)
+			}
+			vv[i] = val
+		}
+		return vv, nil
+	case []string:
+		for i, v := range vv {
+			val, err := k.resolveValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %v", i, err)
+			}
+			vv[i] = val.(string)
+		}
+		return vv, nil
+	}
+	return value, nil
+}
+
+// resolveConfig 处理配置中所有的字串
+// 替换 ${ENV_NAME:-default}，并解密 ENC(...)，直接修改传入的配置
+// 出错时返回出错的路径
+func (k *App) resolveConfig(config Map) error {
+	for key, value := range config {
+		val, err := k.resolveValue(value)
+		if err != nil {
+			if _, ok := value.(Map); ok {
+				return fmt.Errorf("%s.%v", key, err)
+			}
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		config[key] = val
+	}
+	return nil
+}
+
+// Secret 加密配置值，返回可以直接写到配置文件中的 ENC(...)
+// 密钥从环境变量 CHEF_SECRET_KEY 读取
+func (k *App) Secret(value string) (string, error) {
+	text, err := k.codec.Encrypt(AEAD, value)
+	if err != nil {
+		return "", err
+	}
+	return "ENC(" + text + ")", nil
+}
+
+// Secret 加密配置值，返回可以直接写到配置文件中的 ENC(...)
+func Secret(value string) (string, error) {
+	return core.Secret(value)
+}
@@ -0,0 +1,232 @@
+package chef
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/chefsgo/base"
+	"github.com/chefsgo/util"
+)
+
+// settingValue 按路径获取setting中的值，路径用.分隔，如 mail.host
+// 返回的值是深度复制过的，可以放心修改
+func (this *App) settingValue(path string) (Any, bool) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	var value Any = this.config.setting
+	for _, key := range strings.Split(path, ".") {
+		config, ok := value.(Map)
+		if ok == false {
+			return nil, false
+		}
+		if value, ok = config[key]; ok == false {
+			return nil, false
+		}
+	}
+
+	return cloneValue(value), true
+}
+
+// SettingValue 按路径获取setting中的原始值
+// defs 为默认值，路径不存在时返回
+func (this *App) SettingValue(path string, defs ...Any) Any {
+	if value, ok := this.settingValue(path); ok {
+		return value
+	}
+	if len(defs) > 0 {
+		return defs[0]
+	}
+	return nil
+}
+
+// SettingString 按路径获取setting中的字串
+// 数字、布尔等会转成字串
+func (this *App) SettingString(path string, defs ...string) string {
+	if value, ok := this.settingValue(path); ok && value != nil {
+		if vv, ok := value.(string); ok {
+			return vv
+		}
+		return fmt.Sprintf("%v", value)
+	}
+	if len(defs) > 0 {
+		return defs[0]
+	}
+	return ""
+}
+
+// SettingInt 按路径获取setting中的整数
+// 支持各种整数、浮点数，以及数字字串
+func (this *App) SettingInt(path string, defs ...int64) int64 {
+	if value, ok := this.settingValue(path); ok {
+		switch vv := value.(type) {
+		case int:
+			return int64(vv)
+		case int32:
+			return int64(vv)
+		case int64:
+			return vv
+		case float32:
+			return int64(vv)
+		case float64:
+			return int64(vv)
+		case string:
+			if num, err := strconv.ParseInt(vv, 10, 64); err == nil {
+				return num
+			}
+		}
+	}
+	if len(defs) > 0 {
+		return defs[0]
+	}
+	return 0
+}
+
+// SettingFloat 按路径获取setting中的浮点数
+func (this *App) SettingFloat(path string, defs ...float64) float64 {
+	if value, ok := this.settingValue(path); ok {
+		switch vv := value.(type) {
+		case int:
+			return float64(vv)
+		case int32:
+			return float64(vv)
+		case int64:
+			return float64(vv)
+		case float32:
+			return float64(vv)
+		case float64:
+			return vv
+		case string:
+			if num, err := strconv.ParseFloat(vv, 64); err == nil {
+				return num
+			}
+		}
+	}
+	if len(defs) > 0 {
+		return defs[0]
+	}
+	return 0
+}
+
+// SettingBool 按路径获取setting中的布尔值
+// 字串支持 true, false, 1, 0 等
+func (this *App) SettingBool(path string, defs ...bool) bool {
+	if value, ok := this.settingValue(path); ok {
+		switch vv := value.(type) {
+		case bool:
+			return vv
+		case string:
+			if yes, err := strconv.ParseBool(vv); err == nil {
+				return yes
+			}
+		}
+	}
+	if len(defs) > 0 {
+		return defs[0]
+	}
+	return false
+}
+
+// SettingDuration 按路径获取setting中的时间间隔
+// 字串按 1h30m 这样的格式解析，数字表示秒
+func (this *App) SettingDuration(path string, defs ...time.Duration) time.Duration {
+	if value, ok := this.settingValue(path); ok {
+		switch vv := value.(type) {
+		case time.Duration:
+			return vv
+		case int:
+			return time.Second * time.Duration(vv)
+		case int64:
+			return time.Second * time.Duration(vv)
+		case float64:
+			return time.Duration(vv * float64(time.Second))
+		case string:
+			if dur, err := util.ParseDuration(vv); err == nil {
+				return dur
+			}
+		}
+	}
+	if len(defs) > 0 {
+		return defs[0]
+	}
+	return 0
+}
+
+// SettingMap 按路径获取setting中的下级配置
+func (this *App) SettingMap(path string, defs ...Map) Map {
+	if value, ok := this.settingValue(path); ok {
+		if vv, ok := value.(Map); ok {
+			return vv
+		}
+	}
+	if len(defs) > 0 {
+		return defs[0]
+	}
+	return nil
+}
+
+// SettingStrings 按路径获取setting中的字串数组
+func (this *App) SettingStrings(path string, defs ...[]string) []string {
+	if value, ok := this.settingValue(path); ok {
+		switch vv := value.(type) {
+		case []string:
+			return vv
+		case []Any:
+			strs := []string{}
+			for _, v := range vv {
+				strs = append(strs, fmt.Sprintf("%v", v))
+			}
+			return strs
+		case string:
+			return []string{vv}
+		}
+	}
+	if len(defs) > 0 {
+		return defs[0]
+	}
+	return nil
+}
+
+//------------------------- 默认程序 ----------------------------
+
+// SettingValue 按路径获取默认程序setting中的原始值
+func SettingValue(path string, defs ...Any) Any {
+	return core.SettingValue(path, defs...)
+}
+
+// SettingString 按路径获取默认程序setting中的字串
+func SettingString(path string, defs ...string) string {
+	return core.SettingString(path, defs...)
+}
+
+// SettingInt 按路径获取默认程序setting中的整数
+func SettingInt(path string, defs ...int64) int64 {
+	return core.SettingInt(path, defs...)
+}
+
+// SettingFloat 按路径获取默认程序setting中的浮点数
+func SettingFloat(path string, defs ...float64) float64 {
+	return core.SettingFloat(path, defs...)
+}
+
+// SettingBool 按路径获取默认程序setting中的布尔值
+func SettingBool(path string, defs ...bool) bool {
+	return core.SettingBool(path, defs...)
+}
+
+// SettingDuration 按路径获取默认程序setting中的时间间隔
+func SettingDuration(path string, defs ...time.Duration) time.Duration {
+	return core.SettingDuration(path, defs...)
+}
+
+// SettingMap 按路径获取默认程序setting中的下级配置
+func SettingMap(path string, defs ...Map) Map {
+	return core.SettingMap(path, defs...)
+}
+
+// SettingStrings 按路径获取默认程序setting中的字串数组
+func SettingStrings(path string, defs ...[]string) []string {
+	return core.SettingStrings(path, defs...)
+}
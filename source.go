@@ -0,0 +1,273 @@
+package chef
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	. "github.com/chefsgo/base"
+)
+
+type (
+	// ConfigNode 节点身份，配置源按身份返回配置
+	ConfigNode struct {
+		Name    string
+		Role    string
+		Version string
+	}
+
+	// ConfigSource 配置源
+	// 用于从远程，或是其它地方获取配置
+	ConfigSource interface {
+		// Load 按节点身份加载配置
+		Load(node ConfigNode) (Map, error)
+		// Watch 监听配置变化，有变化时调用handler
+		// 加载出错时也调用handler，由程序记录错误，继续监听
+		// 需要阻塞运行，直到 stopper 关闭
+		Watch(node ConfigNode, handler func(Map, error), stopper <-chan struct{}) error
+	}
+
+	// ConfigDriver 配置源驱动
+	// 按配置中 config 的scheme选择驱动，如 file:///etc/chef, http://127.0.0.1/config
+	ConfigDriver interface {
+		Open(uri string) (ConfigSource, error)
+	}
+
+	// fileConfigDriver 目录配置源驱动
+	fileConfigDriver struct{}
+	// fileConfigSource 从目录中读取配置文件
+	// 按顺序合并 name.toml, role.toml, role-version.toml
+	// 同名的 .json, .yaml, .yml 文件也会读取
+	fileConfigSource struct {
+		dir      string
+		interval time.Duration
+	}
+
+	// httpConfigDriver HTTP配置源驱动
+	httpConfigDriver struct{}
+	// httpConfigSource 从HTTP接口获取配置
+	// 请求时带上 name, role, version 参数，按Content-Type解码JSON，YAML或是TOML
+	// uri 中的 interval 为轮询间隔，timeout 为请求超时，默认都是10秒
+	httpConfigSource struct {
+		url      *url.URL
+		interval time.Duration
+		client   *http.Client
+	}
+)
+
+// sourceInterval 从uri的参数中获取轮询间隔，默认10秒
+func sourceInterval(uri *url.URL) time.Duration {
+	query := uri.Query()
+	if interval := parseDurationFromMap(Map{"interval": query.Get("interval")}, "interval"); interval > 0 {
+		return interval
+	}
+	return time.Second * 10
+}
+
+// decodeConfig 按Content-Type解码配置，支持JSON，YAML和TOML，默认JSON
+func decodeConfig(body []byte, format string) (Map, error) {
+	if strings.Contains(format, "toml") {
+		return parseTOML(string(body))
+	}
+	if strings.Contains(format, "yaml") {
+		return parseYAML(string(body))
+	}
+	return parseJSON(string(body))
+}
+
+//------------------------- file ----------------------------
+
+func (driver *fileConfigDriver) Open(uri string) (ConfigSource, error) {
+	if strings.Contains(uri, "://") == false {
+		uri = "file://" + uri
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := u.Host + u.Path
+	if stat, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if stat.IsDir() == false {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	return &fileConfigSource{dir, sourceInterval(u)}, nil
+}
+
+// files 按节点身份，列出需要读取的文件
+func (source *fileConfigSource) files(node ConfigNode) []string {
+	names := []string{node.Name, node.Role}
+	if node.Version != "" {
+		names = append(names, node.Role+"-"+node.Version)
+	}
+
+	files := []string{}
+	exists := map[string]bool{}
+	for _, name := range names {
+		if name == "" || exists[name] {
+			continue
+		}
+		exists[name] = true
+		for _, ext := range []string{".toml", ".json", ".yaml", ".yml"} {
+			files = append(files, path.Join(source.dir, name+ext))
+		}
+	}
+	return files
+}
+
+// Load 读取并合并目录中的配置文件，文件不存在的跳过
+func (source *fileConfigSource) Load(node ConfigNode) (Map, error) {
+	config := Map{}
+	for _, file := range source.files(node) {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			continue
+		}
+		vv, err := readConfig(file, map[string]bool{})
+		if err != nil {
+			return nil, err
+		}
+		mergeMap(config, vv)
+	}
+	return config, nil
+}
+
+// signature 文件的修改时间和大小，用于判断是否有变化
+func (source *fileConfigSource) signature(node ConfigNode) string {
+	sign := ""
+	for _, file := range source.files(node) {
+		if stat, err := os.Stat(file); err == nil {
+			sign += fmt.Sprintf("%s:%d:%d;", file, stat.ModTime().UnixNano(), stat.Size())
+		}
+	}
+	return sign
+}
+
+// Watch 定时检查文件是否有变化
+func (source *fileConfigSource) Watch(node ConfigNode, handler func(Map, error), stopper <-chan struct{}) error {
+	last := source.signature(node)
+
+	ticker := time.NewTicker(source.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopper:
+			return nil
+		case <-ticker.C:
+			sign := source.signature(node)
+			if sign == last {
+				continue
+			}
+			last = sign
+
+			config, err := source.Load(node)
+			if err != nil {
+				handler(nil, fmt.Errorf("config %s: %v", source.dir, err))
+				continue
+			}
+			handler(config, nil)
+		}
+	}
+}
+
+//------------------------- http ----------------------------
+
+func (driver *httpConfigDriver) Open(uri string) (ConfigSource, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	interval := sourceInterval(u)
+
+	query := u.Query()
+	timeout := time.Second * 10
+	if vv := parseDurationFromMap(Map{"timeout": query.Get("timeout")}, "timeout"); vv > 0 {
+		timeout = vv
+	}
+
+	// interval 和 timeout 是给客户端用的，不用传给服务端
+	query.Del("interval")
+	query.Del("timeout")
+	u.RawQuery = query.Encode()
+
+	return &httpConfigSource{
+		url: u, interval: interval,
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// fetch 请求配置，返回原始内容和格式
+func (source *httpConfigSource) fetch(node ConfigNode) ([]byte, string, error) {
+	u := *source.url
+	query := u.Query()
+	query.Set("name", node.Name)
+	query.Set("role", node.Role)
+	query.Set("version", node.Version)
+	u.RawQuery = query.Encode()
+
+	resp, err := source.client.Get(u.String())
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+// Load 请求并解码配置
+func (source *httpConfigSource) Load(node ConfigNode) (Map, error) {
+	body, format, err := source.fetch(node)
+	if err != nil {
+		return nil, err
+	}
+	return decodeConfig(body, format)
+}
+
+// Watch 定时请求，内容有变化时通知
+func (source *httpConfigSource) Watch(node ConfigNode, handler func(Map, error), stopper <-chan struct{}) error {
+	last, _, _ := source.fetch(node)
+
+	ticker := time.NewTicker(source.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopper:
+			return nil
+		case <-ticker.C:
+			body, format, err := source.fetch(node)
+			if err != nil {
+				handler(nil, fmt.Errorf("config %s: %v", source.url.String(), err))
+				continue
+			}
+			if bytes.Equal(body, last) {
+				continue
+			}
+			last = body
+
+			config, err := decodeConfig(body, format)
+			if err != nil {
+				handler(nil, fmt.Errorf("config %s: %v", source.url.String(), err))
+				continue
+			}
+			handler(config, nil)
+		}
+	}
+}
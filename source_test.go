@@ -0,0 +1,82 @@
+package chef_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/chefsgo/base"
+	"github.com/chefsgo/chef"
+)
+
+func TestHTTPConfigSource(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config":
+			if r.URL.Query().Get("role") != "api" || r.URL.Query().Get("timeout") != "" {
+				http.Error(w, "bad query "+r.URL.RawQuery, http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"setting": {"host": "db.local", "port": 3306}}`))
+		case "/slow":
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	t.Run("load", func(t *testing.T) {
+		app := chef.New()
+		app.Identify("api")
+		app.Configure(Map{"config": server.URL + "/config?timeout=1s"})
+		if err := app.Ready(); err != nil {
+			t.Fatalf("ready: %v", err)
+		}
+		defer app.Terminate()
+
+		setting := app.Setting()
+		if setting["host"] != "db.local" {
+			t.Errorf("expected host db.local, got %v", setting["host"])
+		}
+		if port := app.SettingInt("port"); port != 3306 {
+			t.Errorf("expected port 3306, got %v", port)
+		}
+	})
+
+	t.Run("status", func(t *testing.T) {
+		app := chef.New()
+		app.Configure(Map{"config": server.URL + "/missing"})
+		err := app.Ready()
+		if err == nil {
+			app.Terminate()
+			t.Fatal("expected error for 404")
+		}
+		if strings.Contains(err.Error(), "404") == false {
+			t.Errorf("expected 404 in error, got %v", err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		app := chef.New()
+		app.Configure(Map{"config": server.URL + "/slow?timeout=50ms"})
+
+		start := time.Now()
+		err := app.Ready()
+		if err == nil {
+			app.Terminate()
+			t.Fatal("expected timeout error")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("timeout took %v", elapsed)
+		}
+	})
+}
@@ -0,0 +1,231 @@
+package chef
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	. "github.com/chefsgo/base"
+)
+
+type (
+	// Task 一次性任务，比如导入数据、清理文件、临时的采集等
+	// 用 Register("name", Task{...}) 注册，使用 app task name --arg=value 运行
+	// 运行前会调用 Ready 准备好各模块，完成后终止
+	Task struct {
+		Name    string
+		Text    string
+		Args    Vars
+		Setting Map
+		// Action 执行的方法，支持以下几种
+		// func(*Context), func(*Context) Res, func(*Context) (Map, Res)，其它类型注册时会panic
+		Action Any
+	}
+)
+
+// task 注册任务
+func (k *App) task(name string, config Task, override bool) {
+	if config.Name == "" {
+		config.Name = name
+	}
+
+	switch config.Action.(type) {
+	case func(*Context), func(*Context) Res, func(*Context) (Map, Res):
+	default:
+		panic("Invalid task action: " + config.Name)
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.tasks == nil {
+		k.tasks = make(map[string]Task, 0)
+	}
+	if override {
+		k.tasks[name] = config
+	} else {
+		if _, ok := k.tasks[name]; ok == false {
+			k.tasks[name] = config
+		}
+	}
+}
+
+// tasking 执行任务，参数按定义解析
+// 需要先 Ready
+func (k *App) tasking(name string, value Map) (Map, Res) {
+	k.mutex.RLock()
+	config, ok := k.tasks[name]
+	k.mutex.RUnlock()
+
+	if ok == false {
+		return nil, Nothing
+	}
+	if value == nil {
+		value = Map{}
+	}
+
+	meta := k.Meta()
+	defer meta.close()
+
+	ctx := &Context{Meta: meta}
+	ctx.Name = name
+	ctx.Setting = Map{}
+	for key, val := range config.Setting {
+		ctx.Setting[key] = val
+	}
+
+	args := Map{}
+	if config.Args != nil {
+		res := k.basic.Mapping(config.Args, value, args, false, false, ctx.Timezone())
+		if res != nil && res.Fail() {
+			return nil, res
+		}
+	}
+	ctx.Value = value
+	ctx.Args = args
+
+	var data Map
+	result := OK
+
+	switch ff := config.Action.(type) {
+	case func(*Context):
+		ff(ctx)
+	case func(*Context) Res:
+		result = ff(ctx)
+	case func(*Context) (Map, Res):
+		data, result = ff(ctx)
+	}
+
+	if result == nil {
+		result = OK
+	}
+	return data, result
+}
+
+// taskArgs 解析任务参数，支持 --key=value, --key value, 单独的 --key 表示 true
+// 只有任务定义过的非布尔参数，才会把下一个参数当作值，其它的不会吞掉后面的参数
+// chef 自己的参数，如 --config, --mode, --set 等，以及配置文件，返回给启动使用
+func taskArgs(args []string, vars Vars) (Map, []string) {
+	value := Map{}
+	rests := []string{}
+
+	chefs := map[string]bool{"config": true, "name": true, "role": true, "version": true, "mode": true, "set": true}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if strings.HasPrefix(arg, "-") == false {
+			rests = append(rests, arg)
+			continue
+		}
+
+		key := strings.TrimLeft(arg, "-")
+		val, hasValue := "", false
+		if kv := strings.SplitN(key, "=", 2); len(kv) == 2 {
+			key, val, hasValue = kv[0], kv[1], true
+		} else if i+1 < len(args) && strings.HasPrefix(args[i+1], "-") == false && taskValued(key, vars, chefs) {
+			val, hasValue = args[i+1], true
+			i++
+		}
+
+		if chefs[key] {
+			rests = append(rests, "--"+key)
+			if hasValue {
+				rests = append(rests, val)
+			}
+			continue
+		}
+
+		if hasValue {
+			value[key] = val
+		} else {
+			value[key] = "true"
+		}
+	}
+
+	return value, rests
+}
+
+// taskValued 参数是否需要带值
+// chef 自己的参数，以及任务定义过的非布尔参数需要
+func taskValued(key string, vars Vars, chefs map[string]bool) bool {
+	if chefs[key] {
+		return true
+	}
+	config, ok := vars[key]
+	if ok == false {
+		return false
+	}
+	switch strings.ToLower(config.Type) {
+	case "bool", "boolean":
+		return false
+	}
+	return true
+}
+
+// exitCode 按结果返回退出码
+// 成功为0，状态码超出范围的统一为1
+func exitCode(res Res) int {
+	if res == nil || res.OK() {
+		return 0
+	}
+	if code := res.Code(); code > 0 && code < 256 {
+		return code
+	}
+	return 1
+}
+
+// taskCommand 运行任务，不带任务名称时列出所有任务
+func taskCommand(app *App, args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		app.mutex.RLock()
+		tasks := map[string]Task{}
+		names := []string{}
+		for name, config := range app.tasks {
+			tasks[name] = config
+			names = append(names, name)
+		}
+		app.mutex.RUnlock()
+
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Println(name, tasks[name].Text)
+		}
+		return 0
+	}
+
+	// 先确认任务存在，不存在的不需要准备模块
+	name := args[0]
+	app.mutex.RLock()
+	config, ok := app.tasks[name]
+	app.mutex.RUnlock()
+	if ok == false {
+		fmt.Fprintf(os.Stderr, "task %s not found\n", name)
+		return 1
+	}
+
+	value, rests := taskArgs(args[1:], config.Args)
+	app.mutex.Lock()
+	app.args = append([]string{app.args[0]}, rests...)
+	app.mutex.Unlock()
+
+	if err := app.Ready(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer app.terminate()
+
+	data, res := app.tasking(name, value)
+	text := app.basic.String(DEFAULT, res.State(), res.Args()...)
+
+	if data != nil {
+		output(data)
+	}
+	if res.Fail() {
+		fmt.Fprintf(os.Stderr, "task %s %s\n", name, text)
+	} else {
+		fmt.Println(fmt.Sprintf("task %s %s", name, text))
+	}
+
+	return exitCode(res)
+}
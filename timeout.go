@@ -0,0 +1,197 @@
+package chef
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/chefsgo/base"
+)
+
+// Timeout 调用方法的超时时间，会覆盖方法定义的 Timeout
+// 对之后通过此 Meta 发起的调用都有效，为0表示使用方法定义的
+func (meta *Meta) Timeout(timeouts ...time.Duration) time.Duration {
+	if len(timeouts) > 0 {
+		meta.timeout = timeouts[0]
+	}
+	return meta.timeout
+}
+
+// WithContext 指定调用方的 context，之后通过此 Meta 发起的调用都在它的期限内
+// 调用方取消时，正在进行的调用返回 Canceled
+func (meta *Meta) WithContext(cx context.Context) *Meta {
+	meta.ctx = cx
+	return meta
+}
+
+// Context 当前调用的 context，带有期限和取消
+// 没有在调用中，也没有用 WithContext 指定时，返回 context.Background()
+func (meta *Meta) Context() context.Context {
+	if meta.ctx != nil {
+		return meta.ctx
+	}
+	return context.Background()
+}
+
+// Deadline 当前调用的期限，嵌套调用不会超过上级的期限
+func (meta *Meta) Deadline() (time.Time, bool) {
+	return meta.Context().Deadline()
+}
+
+// Done 调用超时或是被取消时关闭
+func (meta *Meta) Done() <-chan struct{} {
+	return meta.Context().Done()
+}
+
+// contextResult 按 context 的错误返回 Timeout 或是 Canceled
+func contextResult(err error) Res {
+	if err == context.DeadlineExceeded {
+		return Timeout
+	}
+	return Canceled
+}
+
+// fork 给有期限的调用使用的 Meta，带上新的 context
+// 超时以后方法可能还在执行，不能和调用方共用同一个 Meta
+// 不带上调用时指定的超时，嵌套调用使用自己的超时，由 context 保证不超过上级的期限
+func (meta *Meta) fork(cx context.Context) *Meta {
+	meta.mutex.RLock()
+	defer meta.mutex.RUnlock()
+
+	return &Meta{
+		app: meta.app, name: meta.name, payload: meta.payload,
+		retries: meta.retries, language: meta.language, timezone: meta.timezone,
+		token: meta.token, trace: meta.trace, verify: meta.verify,
+		ctx: cx,
+	}
+}
+
+// merge 调用完成以后，把方法中对 Meta 的修改带回调用方
+func (meta *Meta) merge(fork *Meta) {
+	meta.mutex.Lock()
+	defer meta.mutex.Unlock()
+
+	meta.language = fork.language
+	meta.timezone = fork.timezone
+	meta.token = fork.token
+	meta.trace = fork.trace
+	meta.verify = fork.verify
+	meta.tempfiles = append(meta.tempfiles, fork.tempfiles...)
+}
+
+// limit 在期限内执行调用
+// 没有超时，也不能取消的，直接执行，否则在另外的协程中执行，到期时返回 Timeout
+// 执行中的 panic 会在调用的协程中重新抛出
+func (module *engineModule) limit(ctx *Context, timeout time.Duration, invoke func() (Map, Res, string)) (Map, Res, string) {
+	meta := ctx.Meta
+	parent := meta.Context()
+	if err := parent.Err(); err != nil {
+		return module.limited(ctx, meta, timeout, err)
+	}
+	if timeout <= 0 && parent.Done() == nil {
+		return invoke()
+	}
+
+	var cx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		cx, cancel = context.WithTimeout(parent, timeout)
+	} else {
+		cx, cancel = context.WithCancel(parent)
+	}
+	defer cancel()
+
+	// 方法和它发起的嵌套调用，都在此期限内
+	fork := meta.fork(cx)
+	ctx.Meta = fork
+
+	type outcome struct {
+		data     Map
+		res      Res
+		tttt     string
+		panicked bool
+		panic    Any
+	}
+	done := make(chan outcome, 1)
+
+	// abandoned 已经超时，方法执行完以后自己清理
+	var mutex sync.Mutex
+	abandoned := false
+
+	go func() {
+		out := outcome{}
+		defer func() {
+			if rec := recover(); rec != nil {
+				out.panicked, out.panic = true, rec
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if abandoned {
+				// 调用方已经返回，没有人再处理这个panic，只能记录下来
+				if out.panicked {
+					meta.Error("invoke panic after "+contextResult(cx.Err()).State(), Map{
+						"method": ctx.Name, "panic": fmt.Sprintf("%v", out.panic),
+					})
+				}
+				fork.close()
+			} else {
+				done <- out
+			}
+		}()
+		out.data, out.res, out.tttt = invoke()
+	}()
+
+	select {
+	case out := <-done:
+		meta.merge(fork)
+		if out.panicked {
+			panic(out.panic)
+		}
+		return out.data, out.res, out.tttt
+	case <-cx.Done():
+		mutex.Lock()
+		abandoned = true
+		select {
+		case <-done:
+			fork.close()
+		default:
+		}
+		mutex.Unlock()
+		return module.limited(ctx, meta, timeout, cx.Err())
+	}
+}
+
+// limited 超时或是取消的调用，在调用方记录日志
+// 方法还在另外的协程中执行，拦截器看不到这个结果
+func (module *engineModule) limited(ctx *Context, meta *Meta, timeout time.Duration, err error) (Map, Res, string) {
+	res := contextResult(err)
+	meta.Warning("invoke "+res.State(), Map{
+		"method": ctx.Name, "timeout": timeout.String(), "code": res.Code(), "state": res.State(),
+	})
+	return nil, res, engineInvoke
+}
+
+// triggering 异步触发的调用，程序停止时取消
+// 不继承调用方的期限，调用方返回以后仍然会执行
+func (module *engineModule) triggering(meta *Meta) (*Meta, context.CancelFunc) {
+	// 异步执行，不和调用方共用 Meta
+	trigger := module.app.Meta()
+	if meta != nil {
+		trigger = module.app.Meta(meta.Metadata())
+		trigger.timeout = meta.timeout
+	}
+
+	cx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-module.app.stopper:
+			cancel()
+		case <-cx.Done():
+		}
+	}()
+	trigger.ctx = cx
+
+	return trigger, cancel
+}
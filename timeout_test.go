@@ -0,0 +1,164 @@
+package chef_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/chefsgo/base"
+	"github.com/chefsgo/chef"
+	"github.com/chefsgo/chef/cheftest"
+)
+
+func TestTimeout(t *testing.T) {
+	app := cheftest.New(t,
+		"slow", chef.Method{
+			Timeout: 20 * time.Millisecond,
+			Action: func(ctx *chef.Context) {
+				<-ctx.Done()
+			},
+		},
+		"hang", chef.Method{
+			Timeout: time.Hour,
+			Action: func(ctx *chef.Context) {
+				<-ctx.Done()
+			},
+		},
+	)
+
+	meta := app.Meta()
+	meta.Invoke("slow")
+	app.AssertState(meta.Result(), chef.Timeout)
+
+	// 调用时指定的超时优先，不用等到方法自己的超时
+	meta.Timeout(10 * time.Millisecond)
+	start := time.Now()
+	meta.Invoke("hang")
+	app.AssertState(meta.Result(), chef.Timeout)
+	if elapsed := time.Since(start); elapsed > time.Minute {
+		t.Errorf("meta timeout should win, took %v", elapsed)
+	}
+}
+
+func TestNestedTimeout(t *testing.T) {
+	// 超时后方法还在放弃的协程里执行，结果通过通道传出
+	outerDeadline := make(chan time.Time, 1)
+	innerDeadline := make(chan time.Time, 1)
+	inner := make(chan Res, 1)
+	innerDone := make(chan time.Time, 1)
+
+	app := cheftest.New(t,
+		"outer", chef.Method{
+			Action: func(ctx *chef.Context) {
+				deadline, _ := ctx.Deadline()
+				outerDeadline <- deadline
+				ctx.Invoke("inner")
+				inner <- ctx.Result()
+				innerDone <- time.Now()
+				ctx.Invoke("wait")
+			},
+		},
+		"inner", chef.Method{
+			Timeout: 10 * time.Millisecond,
+			Action: func(ctx *chef.Context) {
+				<-ctx.Done()
+			},
+		},
+		"wait", chef.Method{
+			Timeout: time.Hour,
+			Action: func(ctx *chef.Context) {
+				deadline, _ := ctx.Deadline()
+				innerDeadline <- deadline
+				<-ctx.Done()
+			},
+		},
+	)
+
+	meta := app.Meta()
+	meta.Timeout(200 * time.Millisecond)
+	start := time.Now()
+	meta.Invoke("outer")
+	app.AssertState(meta.Result(), chef.Timeout)
+
+	// 嵌套调用使用自己的超时，不继承调用时指定的，在上级的期限之前就超时了
+	outer := <-outerDeadline
+	if res := <-inner; res == nil || res.State() != chef.Timeout.State() {
+		t.Errorf("expected inner timeout, got %v", res)
+	}
+	if done := <-innerDone; done.Before(outer) == false {
+		t.Errorf("inner should time out by its own timeout, done %v, outer deadline %v", done, outer)
+	}
+	// 嵌套调用不会超过上级的期限
+	nested := <-innerDeadline
+	if nested.IsZero() || nested.After(outer) {
+		t.Errorf("inner deadline %v should not be after outer %v", nested, outer)
+	}
+	if elapsed := time.Since(start); elapsed > time.Minute {
+		t.Errorf("nested call took %v", elapsed)
+	}
+}
+
+func TestTimeoutPanic(t *testing.T) {
+	app := cheftest.New(t,
+		"panic", chef.Method{
+			Timeout: time.Second,
+			Action: func(ctx *chef.Context) {
+				panic("boom")
+			},
+		},
+	)
+
+	defer func() {
+		if rec := recover(); rec != "boom" {
+			t.Errorf("expected panic boom, got %v", rec)
+		}
+	}()
+	app.Meta().Invoke("panic")
+	t.Error("expected panic")
+}
+
+func TestWithContext(t *testing.T) {
+	started := make(chan struct{})
+	app := cheftest.New(t,
+		"wait", chef.Method{
+			Action: func(ctx *chef.Context) {
+				close(started)
+				<-ctx.Done()
+			},
+		},
+	)
+
+	cx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	meta := app.Meta().WithContext(cx)
+	meta.Invoke("wait")
+	app.AssertState(meta.Result(), chef.Canceled)
+}
+
+func TestTriggerCancel(t *testing.T) {
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+
+	app := cheftest.New(t, "wait", chef.Method{
+		Action: func(ctx *chef.Context) {
+			close(started)
+			<-ctx.Done()
+			close(canceled)
+		},
+	})
+
+	app.Trigger("wait")
+	<-started
+
+	// 程序停止时，异步的调用会被取消
+	app.Terminate()
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("trigger not canceled on stop")
+	}
+}
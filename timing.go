@@ -0,0 +1,235 @@
+package chef
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	. "github.com/chefsgo/base"
+)
+
+const (
+	phaseTerminate = "terminate"
+)
+
+type (
+	// Timing 模块在一个阶段的耗时
+	// 启动和退出时，每个模块的 initialize, connect, launch, terminate 都会记录
+	Timing struct {
+		Module   string        `json:"module"`
+		Phase    string        `json:"phase"`
+		Start    time.Time     `json:"start"`
+		Duration time.Duration `json:"duration"`
+		// Panic 发生panic时的信息
+		Panic string `json:"panic,omitempty"`
+		// Error 返回的错误
+		Error string `json:"error,omitempty"`
+		// Timeout 终止超时被跳过
+		Timeout bool `json:"timeout,omitempty"`
+		// Budget 阶段的预算，为0表示没有设置
+		Budget time.Duration `json:"budget,omitempty"`
+	}
+
+	// timings 耗时记录和阶段预算
+	timings struct {
+		mutex   sync.Mutex
+		records []Timing
+		// budgets 阶段的预算，如 connect，或是 mysql.connect 指定模块
+		budgets map[string]time.Duration
+	}
+)
+
+// Exceeded 是否超出预算
+func (timing Timing) Exceeded() bool {
+	return timing.Budget > 0 && timing.Duration > timing.Budget
+}
+
+// budget 设置阶段预算
+// 配置如 [budget] connect = "5s"，单个模块的如 [budget.mysql] connect = "10s"
+func (k *App) budget(config Map) {
+	budgets := make(map[string]time.Duration, 0)
+	for _, phase := range []string{phaseInitialize, phaseConnect, phaseLaunch, phaseTerminate} {
+		if dur := parseDurationFromMap(config, phase); dur >= 0 {
+			budgets[phase] = dur
+		}
+	}
+	for name, vv := range config {
+		if module, ok := vv.(Map); ok {
+			for _, phase := range []string{phaseInitialize, phaseConnect, phaseLaunch, phaseTerminate} {
+				if dur := parseDurationFromMap(module, phase); dur >= 0 {
+					budgets[name+"."+phase] = dur
+				}
+			}
+		}
+	}
+
+	k.timings.mutex.Lock()
+	k.timings.budgets = budgets
+	k.timings.mutex.Unlock()
+}
+
+// timed 记录一次耗时，超出预算时记录警告日志
+func (k *App) timed(timing Timing) {
+	k.timings.mutex.Lock()
+	if dur, ok := k.timings.budgets[timing.Module+"."+timing.Phase]; ok {
+		timing.Budget = dur
+	} else if dur, ok := k.timings.budgets[timing.Phase]; ok {
+		timing.Budget = dur
+	}
+	k.timings.records = append(k.timings.records, timing)
+	k.timings.mutex.Unlock()
+
+	if timing.Exceeded() {
+		k.Warning("over budget", Map{"module": timing.Module, "phase": timing.Phase, "duration": timing.Duration.String(), "budget": timing.Budget.String()})
+	}
+}
+
+// perform 执行模块的生命周期方法，并记录耗时
+func (k *App) perform(mod Module, phase string) error {
+	timing := Timing{Module: moduleName(mod), Phase: phase, Start: time.Now()}
+	err := modulePerform(mod, phase)
+	timing.Duration = time.Since(timing.Start)
+
+	if failure, ok := err.(*Failure); ok {
+		if failure.Panic {
+			timing.Panic = failure.Err.Error()
+		} else {
+			timing.Error = failure.Err.Error()
+		}
+	}
+	k.timed(timing)
+	return err
+}
+
+// terminating 终止单个模块，有超时，并记录耗时
+func (k *App) terminating(mod Module) {
+	timing := Timing{Module: moduleName(mod), Phase: phaseTerminate, Start: time.Now()}
+
+	panics := make(chan string, 1)
+	done := k.runTimeout(k.config.shutdown, func() {
+		defer func() {
+			if rec := recover(); rec != nil {
+				panics <- fmt.Sprintf("%v", rec)
+			}
+		}()
+		mod.Terminate()
+	})
+	timing.Duration = time.Since(timing.Start)
+
+	if done {
+		select {
+		case timing.Panic = <-panics:
+			k.Error("terminate panic", Map{"module": timing.Module, "panic": timing.Panic})
+		default:
+		}
+	} else {
+		timing.Timeout = true
+		k.Warning("terminate timeout, skipped", Map{"module": timing.Module})
+	}
+	k.timed(timing)
+}
+
+// Timings 获取所有模块各阶段的耗时，按记录的顺序
+func (k *App) Timings() []Timing {
+	k.timings.mutex.Lock()
+	defer k.timings.mutex.Unlock()
+
+	timings := make([]Timing, len(k.timings.records))
+	copy(timings, k.timings.records)
+	return timings
+}
+
+// timingReport 开发模式下，输出各模块在阶段中的耗时表格
+func (k *App) timingReport(title string, phases ...string) {
+	if k.config.mode != developing {
+		return
+	}
+	if table := timingTable(k.Timings(), phases...); table != "" {
+		k.Info(title + "\n" + table)
+	}
+}
+
+// timingTable 按模块汇总的耗时表格，同一模块同一阶段多次记录的，使用最后一次
+func timingTable(timings []Timing, phases ...string) string {
+	modules := []string{}
+	cells := map[string]map[string]Timing{}
+	totals := map[string]time.Duration{}
+
+	for _, timing := range timings {
+		found := false
+		for _, phase := range phases {
+			if phase == timing.Phase {
+				found = true
+			}
+		}
+		if found == false {
+			continue
+		}
+
+		if _, ok := cells[timing.Module]; ok == false {
+			cells[timing.Module] = map[string]Timing{}
+			modules = append(modules, timing.Module)
+		}
+		cells[timing.Module][timing.Phase] = timing
+	}
+	if len(modules) == 0 {
+		return ""
+	}
+
+	buffer := &bytes.Buffer{}
+	writer := tabwriter.NewWriter(buffer, 0, 4, 2, ' ', 0)
+
+	fmt.Fprint(writer, "module")
+	for _, phase := range phases {
+		fmt.Fprintf(writer, "\t%s", phase)
+	}
+	fmt.Fprintln(writer, "\ttotal")
+
+	for _, module := range modules {
+		var total time.Duration
+		fmt.Fprint(writer, module)
+		for _, phase := range phases {
+			timing, ok := cells[module][phase]
+			if ok == false {
+				fmt.Fprint(writer, "\t-")
+				continue
+			}
+
+			cell := timing.Duration.Round(time.Microsecond).String()
+			if timing.Panic != "" {
+				cell += " panic"
+			} else if timing.Error != "" {
+				cell += " error"
+			} else if timing.Timeout {
+				cell += " timeout"
+			} else if timing.Exceeded() {
+				cell += " over"
+			}
+			fmt.Fprintf(writer, "\t%s", cell)
+
+			total += timing.Duration
+			totals[phase] += timing.Duration
+		}
+		fmt.Fprintf(writer, "\t%s\n", total.Round(time.Microsecond))
+	}
+
+	var total time.Duration
+	fmt.Fprint(writer, "total")
+	for _, phase := range phases {
+		fmt.Fprintf(writer, "\t%s", totals[phase].Round(time.Microsecond))
+		total += totals[phase]
+	}
+	fmt.Fprintf(writer, "\t%s\n", total.Round(time.Microsecond))
+
+	writer.Flush()
+	return strings.TrimSuffix(buffer.String(), "\n")
+}
+
+// Timings 获取所有模块各阶段的耗时
+// 可以用来查看启动慢在哪个模块，超出 [budget] 配置的阶段预算时会记录警告日志
+func Timings() []Timing {
+	return core.Timings()
+}
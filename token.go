@@ -9,16 +9,18 @@ import (
 	"time"
 
 	. "github.com/chefsgo/base"
-	"github.com/chefsgo/util"
 )
 
-var (
-	mToken = &tokenModule{
+func newTokenModule(app *App) *tokenModule {
+	return &tokenModule{
+		app: app,
 		config: tokenConfig{
 			Secret: CHEFSGO,
 		},
 	}
+}
 
+var (
 	errInvalidToken = errors.New("Invalid token.")
 )
 
@@ -41,11 +43,22 @@ type (
 	}
 
 	tokenModule struct {
+		app    *App
 		mutex  sync.Mutex
 		config tokenConfig
 	}
 )
 
+// Name 模块名称
+func (module *tokenModule) Name() string {
+	return "token"
+}
+
+// Depends 签名时需要codec加密
+func (module *tokenModule) Depends() []string {
+	return []string{"codec"}
+}
+
 // Register
 func (module *tokenModule) Register(name string, value Any, override bool) {
 	// switch val := value.(type) {
@@ -62,21 +75,12 @@ func (module *tokenModule) Configure(global Map) {
 	}
 
 	if secret, ok := config["secret"].(string); ok {
-		mToken.config.Secret = secret
+		module.config.Secret = secret
 	}
 
 	//默认过期时间，单位秒
-	if expiry, ok := config["expiry"].(string); ok {
-		dur, err := util.ParseDuration(expiry)
-		if err == nil {
-			mToken.config.Expiry = dur
-		}
-	}
-	if expiry, ok := config["expiry"].(int); ok {
-		mToken.config.Expiry = time.Second * time.Duration(expiry)
-	}
-	if expiry, ok := config["expiry"].(float64); ok {
-		mToken.config.Expiry = time.Second * time.Duration(expiry)
+	if expiry := parseDurationFromMap(config, "expiry"); expiry >= 0 {
+		module.config.Expiry = expiry
 	}
 }
 
@@ -94,17 +98,17 @@ func (this *tokenModule) Terminate() {
 func (this *tokenModule) Sign(token *Token) (string, error) {
 	header, payload := "{}", "{}"
 
-	if vv, err := MarshalJSON(token.Header); err != nil {
+	if vv, err := this.app.codec.MarshalJSON(token.Header); err != nil {
 		return "", err
 	} else {
-		if vvs, err := mCodec.EncryptTEXT(string(vv)); err != nil {
+		if vvs, err := this.app.codec.EncryptTEXT(string(vv)); err != nil {
 			return "", err
 		} else {
 			header = vvs
 		}
 	}
 
-	if vv, err := MarshalJSON(token.Payload); err != nil {
+	if vv, err := this.app.codec.MarshalJSON(token.Payload); err != nil {
 		return "", err
 	} else {
 		payload = base64.URLEncoding.EncodeToString(vv)
@@ -142,10 +146,10 @@ func (this *tokenModule) Verify(str string) (*Token, error) {
 
 	token := &Token{}
 
-	if vvs, err := mCodec.DecryptTEXT(header); err != nil {
+	if vvs, err := this.app.codec.DecryptTEXT(header); err != nil {
 		return nil, err
 	} else {
-		if err := UnmarshalJSON([]byte(vvs), &token.Header); err != nil {
+		if err := this.app.codec.UnmarshalJSON([]byte(vvs), &token.Header); err != nil {
 			return nil, err
 		}
 	}
@@ -153,7 +157,7 @@ func (this *tokenModule) Verify(str string) (*Token, error) {
 	if vvs, err := base64.URLEncoding.DecodeString(payload); err != nil {
 		return nil, err
 	} else {
-		if err := UnmarshalJSON(vvs, &token.Payload); err != nil {
+		if err := this.app.codec.UnmarshalJSON(vvs, &token.Payload); err != nil {
 			return nil, err
 		}
 	}
@@ -176,22 +180,10 @@ func (this *tokenModule) Verify(str string) (*Token, error) {
 // Sign 生成签名
 // 可以用在一些批量生成的场景
 func Sign(auth bool, payload Map, ends ...time.Duration) string {
-	verify := &Token{Payload: payload}
-	verify.Header.Id = mCodec.Generate()
-	verify.Header.Auth = auth
-
-	now := time.Now()
-	if len(ends) > 0 {
-		verify.Header.End = now.Add(ends[0]).Unix()
-	}
-	token, err := mToken.Sign(verify)
-	if err != nil {
-		return ""
-	}
-	return token
+	return core.Sign(auth, payload, ends...)
 }
 
 // Verify
 func Verify(token string) (*Token, error) {
-	return mToken.Verify(token)
+	return core.Verify(token)
 }
@@ -4,9 +4,16 @@ import (
 	"crypto"
 	"crypto/hmac"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"time"
 
@@ -14,12 +21,20 @@ import (
 	"github.com/chefsgo/util"
 
 	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
 var (
 	errHashUnavaliable = errors.New("Hash unavailable.")
 )
 
+const (
+	// envPrefix 环境变量前缀
+	envPrefix = "CHEF_"
+	// envSeparator 环境变量中的层级分隔
+	envSeparator = "__"
+)
+
 type (
 	flagSlice []string
 )
@@ -47,12 +62,431 @@ func getBaseWithoutExt(p string) string {
 }
 
 // parseTOML 解析toml文本得到配置
-func parseTOML(s string) Map {
-	var config Map
-	toml.Decode(s, &config)
+// 错误信息中会带有行号
+func parseTOML(s string) (Map, error) {
+	config := Map{}
+	if _, err := toml.Decode(s, &config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// parseJSON 解析json文本得到配置
+// encoding/json 的错误只有偏移量，这里换算成行号
+func parseJSON(s string) (Map, error) {
+	config := Map{}
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.UseNumber()
+	err := decoder.Decode(&config)
+	if err == nil {
+		if _, err = decoder.Token(); err == io.EOF {
+			return normalizeMap(config), nil
+		} else if err == nil {
+			err = errors.New("invalid character after top-level value")
+		}
+	}
+
+	offset := decoder.InputOffset()
+	if vv, ok := err.(*json.SyntaxError); ok {
+		offset = vv.Offset
+	} else if vv, ok := err.(*json.UnmarshalTypeError); ok {
+		offset = vv.Offset
+	}
+	if offset > int64(len(s)) {
+		offset = int64(len(s))
+	}
+	line := strings.Count(s[:offset], "\n") + 1
+	return nil, fmt.Errorf("line %d: %v", line, err)
+}
+
+// parseYAML 解析yaml文本得到配置
+// 错误信息中会带有行号
+func parseYAML(s string) (Map, error) {
+	config := Map{}
+	if err := yaml.Unmarshal([]byte(s), &config); err != nil {
+		return nil, err
+	}
+	return normalizeMap(config), nil
+}
+
+// normalizeMap 把JSON和YAML中的数字统一为 int64 和 float64，和TOML一致
+// 这样读取配置时，不用关心配置文件是什么格式
+func normalizeMap(config Map) Map {
+	for key, val := range config {
+		config[key] = normalizeValue(val)
+	}
 	return config
 }
 
+func normalizeValue(value Any) Any {
+	switch vv := value.(type) {
+	case json.Number:
+		if num, err := vv.Int64(); err == nil {
+			return num
+		}
+		num, _ := vv.Float64()
+		return num
+	case int:
+		return int64(vv)
+	case uint64:
+		return float64(vv)
+	case Map:
+		return normalizeMap(vv)
+	case map[Any]Any:
+		config := Map{}
+		for key, val := range vv {
+			config[fmt.Sprintf("%v", key)] = normalizeValue(val)
+		}
+		return config
+	case []Any:
+		for i, val := range vv {
+			vv[i] = normalizeValue(val)
+		}
+		return vv
+	}
+	return value
+}
+
+// parseConfig 按格式解析配置，json, yaml, yml, 其它的都当作toml
+func parseConfig(s string, format string) (Map, error) {
+	switch strings.TrimPrefix(strings.ToLower(format), ".") {
+	case "json":
+		return parseJSON(s)
+	case "yaml", "yml":
+		return parseYAML(s)
+	default:
+		return parseTOML(s)
+	}
+}
+
+// readConfig 读取配置文件
+// 先按顺序加载 include 中的文件，再用文件自己的配置覆盖
+// include 中的相对路径，相对于当前文件所在的目录
+// 错误信息中会带上文件名和行号
+func readConfig(file string, visited map[string]bool) (Map, error) {
+	if abs, err := filepath.Abs(file); err == nil {
+		if visited[abs] {
+			return nil, fmt.Errorf("%s: include cycle", file)
+		}
+		visited[abs] = true
+		defer delete(visited, abs)
+	}
+
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := parseConfig(string(bytes), filepath.Ext(file))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	includes := []string{}
+	switch vv := config["include"].(type) {
+	case string:
+		includes = append(includes, vv)
+	case []string:
+		includes = append(includes, vv...)
+	case []Any:
+		for _, v := range vv {
+			if include, ok := v.(string); ok {
+				includes = append(includes, include)
+			}
+		}
+	}
+	delete(config, "include")
+
+	merged := Map{}
+	for _, include := range includes {
+		if filepath.IsAbs(include) == false {
+			include = filepath.Join(filepath.Dir(file), include)
+		}
+		vv, err := readConfig(include, visited)
+		if err != nil {
+			return nil, err
+		}
+		mergeMap(merged, vv)
+	}
+
+	return mergeMap(merged, config), nil
+}
+
+// overlayFile 运行模式对应的覆盖文件
+// 如 config.toml 在 production 模式下对应 config.production.toml
+func overlayFile(file string, mode env) string {
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + "." + modeName(mode) + ext
+}
+
+// parseFlags 解析命令行参数
+// 返回指定的配置文件，以及由参数生成的配置
+// 第一个参数如果不是以-开头，就当作配置文件，兼容 app config.toml 的写法
+// 支持 --key=value 和 --key value，不认识的参数跳过，可能是程序自己要用的
+// 配置文件只能是第一个参数或 --config，后面的值可能属于程序自己的参数，比如 --port 8080
+func parseFlags(args []string) (string, Map) {
+	config := Map{}
+	if len(args) <= 1 {
+		return "", config
+	}
+
+	file := ""
+	args = args[1:]
+	if !strings.HasPrefix(args[0], "-") {
+		file = args[0]
+		args = args[1:]
+	}
+
+	var name, role, version, mode, conf string
+	var sets []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			continue
+		}
+
+		key := strings.TrimLeft(arg, "-")
+		val, hasValue := "", false
+		if kv := strings.SplitN(key, "=", 2); len(kv) == 2 {
+			key, val, hasValue = kv[0], kv[1], true
+		}
+
+		switch key {
+		case "config", "name", "role", "version", "mode", "set":
+		default:
+			continue
+		}
+		if hasValue == false {
+			if i+1 >= len(args) {
+				continue
+			}
+			val = args[i+1]
+			i++
+		}
+
+		switch key {
+		case "config":
+			conf = val
+		case "name":
+			name = val
+		case "role":
+			role = val
+		case "version":
+			version = val
+		case "mode":
+			mode = val
+		case "set":
+			sets = append(sets, val)
+		}
+	}
+
+	if conf != "" {
+		file = conf
+	}
+
+	if name != "" {
+		config["name"] = name
+	}
+	if role != "" {
+		config["role"] = role
+	}
+	if version != "" {
+		config["version"] = version
+	}
+	if mode != "" {
+		config["mode"] = mode
+	}
+
+	for _, set := range sets {
+		kv := strings.SplitN(set, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		setMapValue(config, strings.Split(kv[0], "."), parseValue(kv[1]))
+	}
+
+	return file, config
+}
+
+// parseEnvs 从环境变量中解析配置
+// 只处理 CHEF_ 开头的变量，双下划线表示层级，key全部转小写
+// 密钥 CHEF_SECRET_KEY 除外
+// 比如 CHEF_TOKEN__SECRET=xxx 对应 token.secret
+func parseEnvs(envs []string) Map {
+	config := Map{}
+	for _, env := range envs {
+		kv := strings.SplitN(env, "=", 2)
+		if len(kv) != 2 || !strings.HasPrefix(kv[0], envPrefix) || kv[0] == secretEnv {
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimPrefix(kv[0], envPrefix))
+		if key == "" {
+			continue
+		}
+		setMapValue(config, strings.Split(key, envSeparator), parseValue(kv[1]))
+	}
+	return config
+}
+
+// parseValue 按TOML的值语法解析字串
+// 比如 true, 123, 1.5, ["a","b"] 等，无法解析的当作字串
+// 如果需要强制使用字串，可以加上引号，比如 "1.0"
+func parseValue(s string) Any {
+	var config Map
+	if _, err := toml.Decode("value = "+s, &config); err == nil {
+		if val, ok := config["value"]; ok {
+			return val
+		}
+	}
+	return s
+}
+
+// setMapValue 按路径写入值，中间层级不存在时自动创建
+func setMapValue(config Map, paths []string, value Any) {
+	for i, key := range paths {
+		if i == len(paths)-1 {
+			config[key] = value
+			return
+		}
+		next, ok := config[key].(Map)
+		if !ok {
+			next = Map{}
+			config[key] = next
+		}
+		config = next
+	}
+}
+
+// mergeMap 深度合并配置，src 覆盖 dst
+// 两边都是Map的时候递归合并，其它情况直接替换
+func mergeMap(dst, src Map) Map {
+	for key, val := range src {
+		if sv, ok := val.(Map); ok {
+			if dv, ok := dst[key].(Map); ok {
+				mergeMap(dv, sv)
+				continue
+			}
+			dv := Map{}
+			mergeMap(dv, sv)
+			dst[key] = dv
+			continue
+		}
+		dst[key] = val
+	}
+	return dst
+}
+
+// cloneMap 深度复制配置
+func cloneMap(config Map) Map {
+	if config == nil {
+		return nil
+	}
+	clone := make(Map, len(config))
+	for key, val := range config {
+		clone[key] = cloneValue(val)
+	}
+	return clone
+}
+
+// cloneValue 深度复制值，Map和各种切片都会复制
+func cloneValue(value Any) Any {
+	switch vv := value.(type) {
+	case Map:
+		return cloneMap(vv)
+	case []Map:
+		clone := make([]Map, len(vv))
+		for i, v := range vv {
+			clone[i] = cloneMap(v)
+		}
+		return clone
+	case []Any:
+		clone := make([]Any, len(vv))
+		for i, v := range vv {
+			clone[i] = cloneValue(v)
+		}
+		return clone
+	}
+
+	// 其它类型的切片，元素一般都是值类型，复制一层就够了
+	val := reflect.ValueOf(value)
+	if val.Kind() == reflect.Slice && !val.IsNil() {
+		clone := reflect.MakeSlice(val.Type(), val.Len(), val.Len())
+		reflect.Copy(clone, val)
+		return clone.Interface()
+	}
+	return value
+}
+
+// parseMode 解析运行模式
+func parseMode(mode string) env {
+	mode = strings.ToLower(mode)
+	if mode == "t" || mode == "test" || mode == "testing" {
+		return testing
+	} else if mode == "p" || mode == "prod" || mode == "production" {
+		return production
+	}
+	return developing
+}
+
+// modeName 运行模式的名称
+func modeName(mode env) string {
+	switch mode {
+	case testing:
+		return "testing"
+	case production:
+		return "production"
+	default:
+		return "developing"
+	}
+}
+
+// diffKeys 比较两个配置，返回有变化的路径
+// 下级都是Map时递归比较，路径用.连接
+func diffKeys(old, new Map, prefix string) []string {
+	keys := []string{}
+	for key, nv := range new {
+		path := prefix + key
+		ov, ok := old[key]
+		if !ok {
+			keys = append(keys, path)
+			continue
+		}
+		om, ook := ov.(Map)
+		nm, nok := nv.(Map)
+		if ook && nok {
+			keys = append(keys, diffKeys(om, nm, path+".")...)
+		} else if !reflect.DeepEqual(ov, nv) {
+			keys = append(keys, path)
+		}
+	}
+	for key := range old {
+		if _, ok := new[key]; !ok {
+			keys = append(keys, prefix+key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parseIntFromMap 从配置中读取整数
+// 不同格式的配置文件，数字可能是 int, int64 或是 float64
+func parseIntFromMap(config Map, field string) (int64, bool) {
+	switch vv := config[field].(type) {
+	case int:
+		return int64(vv), true
+	case int64:
+		return vv, true
+	case float64:
+		return int64(vv), true
+	}
+	return 0, false
+}
+
 func parseDurationFromMap(config Map, field string) time.Duration {
 	if expiry, ok := config[field].(string); ok {
 		dur, err := util.ParseDuration(expiry)
@@ -66,6 +500,9 @@ func parseDurationFromMap(config Map, field string) time.Duration {
 	if expiry, ok := config[field].(int64); ok {
 		return time.Second * time.Duration(expiry)
 	}
+	if expiry, ok := config[field].(float64); ok {
+		return time.Duration(float64(time.Second) * expiry)
+	}
 
 	return -1
 }